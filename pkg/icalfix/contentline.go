@@ -0,0 +1,194 @@
+package icalfix
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Helpers for working on serialized iCal text one content line at a time.
+// RFC 5545 section 3.1: long lines are folded by inserting CRLF followed by a single space or tab,
+// and parameter values containing ':', ';' or ',' are enclosed in double quotes.
+
+// maxContentLineOctets is the folding limit for content lines (RFC 5545 section 3.1)
+const maxContentLineOctets = 75
+
+// rewriteContentLines applies rewrite to every unfolded content line of icalData.
+// Lines that rewrite leaves untouched are copied byte for byte; changed lines are refolded.
+func rewriteContentLines(icalData string, rewrite func(line string) string) string {
+	var out strings.Builder
+	out.Grow(len(icalData))
+
+	for len(icalData) > 0 {
+		raw, terminator, rest := nextContentLine(icalData)
+		icalData = rest
+
+		line := unfoldContentLine(raw)
+		if fixed := rewrite(line); fixed != line {
+			out.WriteString(foldContentLine(fixed))
+		} else {
+			out.WriteString(raw)
+		}
+		out.WriteString(terminator)
+	}
+
+	return out.String()
+}
+
+// nextContentLine returns the first content line of data including any folded continuations,
+// the CRLF that terminates it (empty at the end of data), and the remaining data.
+// A CRLF inside a quoted parameter value does not end the line.
+func nextContentLine(data string) (string, string, string) {
+	inQuotes := false
+	inValue := false
+
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '"':
+			if !inValue {
+				inQuotes = !inQuotes
+			}
+		case ':':
+			if !inQuotes {
+				inValue = true
+			}
+		case '\r':
+			if i+1 >= len(data) || data[i+1] != '\n' {
+				continue
+			}
+			// Folded continuation line
+			if i+2 < len(data) && (data[i+2] == ' ' || data[i+2] == '\t') {
+				i++
+				continue
+			}
+			if inQuotes && !inValue {
+				i++
+				continue
+			}
+			return data[:i], "\r\n", data[i+2:]
+		}
+	}
+
+	return data, "", ""
+}
+
+// unfoldContentLine removes the line folding from a raw content line
+func unfoldContentLine(raw string) string {
+	if !strings.Contains(raw, "\r\n") {
+		return raw
+	}
+	unfolded := strings.ReplaceAll(raw, "\r\n ", "")
+	return strings.ReplaceAll(unfolded, "\r\n\t", "")
+}
+
+// foldContentLine folds a content line at maxContentLineOctets without splitting UTF-8 characters
+func foldContentLine(line string) string {
+	if len(line) <= maxContentLineOctets {
+		return line
+	}
+
+	var out strings.Builder
+	limit := maxContentLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		out.WriteString(line[:cut])
+		out.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts towards the limit
+		limit = maxContentLineOctets - 1
+	}
+	out.WriteString(line)
+
+	return out.String()
+}
+
+// contentLine is an unfolded content line split into its property name, parameters, and value.
+// Parameters keep their order, spelling, and quoting, so a fix that only touches the value
+// or removes one parameter leaves the rest of the line exactly as it was.
+type contentLine struct {
+	name   string
+	params []contentParam
+	value  string
+}
+
+// contentParam is one raw parameter of a content line, such as TZID=Europe/Berlin or CN="Doe, Jane"
+type contentParam string
+
+// name returns the parameter name
+func (p contentParam) name() string {
+	name, _, _ := strings.Cut(string(p), "=")
+	return name
+}
+
+// value returns the parameter value without enclosing quotes
+func (p contentParam) value() string {
+	_, value, _ := strings.Cut(string(p), "=")
+	return strings.Trim(value, `"`)
+}
+
+// parseContentLine splits an unfolded content line into its name, parameters, and value.
+// Separators inside quoted parameter values are ignored.
+func parseContentLine(line string) (contentLine, bool) {
+	var parts []string
+	inQuotes := false
+	start := 0
+
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ';':
+			if !inQuotes {
+				parts = append(parts, line[start:i])
+				start = i + 1
+			}
+		case ':':
+			if !inQuotes {
+				parts = append(parts, line[start:i])
+				parsed := contentLine{name: parts[0], value: line[i+1:]}
+				for _, part := range parts[1:] {
+					parsed.params = append(parsed.params, contentParam(part))
+				}
+				return parsed, true
+			}
+		}
+	}
+
+	return contentLine{}, false
+}
+
+// param returns the unquoted value of the first parameter with the given name, matched case-insensitively
+func (l contentLine) param(name string) (string, bool) {
+	for _, param := range l.params {
+		if strings.EqualFold(param.name(), name) {
+			return param.value(), true
+		}
+	}
+	return "", false
+}
+
+// removeParam removes all parameters with the given name
+func (l *contentLine) removeParam(name string) {
+	kept := l.params[:0]
+	for _, param := range l.params {
+		if !strings.EqualFold(param.name(), name) {
+			kept = append(kept, param)
+		}
+	}
+	l.params = kept
+}
+
+// String joins the line back together; it is the inverse of parseContentLine
+func (l contentLine) String() string {
+	var out strings.Builder
+	out.WriteString(l.name)
+	for _, param := range l.params {
+		out.WriteString(";")
+		out.WriteString(string(param))
+	}
+	out.WriteString(":")
+	out.WriteString(l.value)
+	return out.String()
+}
@@ -0,0 +1,75 @@
+package icalfix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+// ErrNonCalendarContent is returned when the upstream served something other than iCal data,
+// typically an HTML error page with status 200
+var ErrNonCalendarContent = errors.New("upstream returned non-calendar content")
+
+// calendarSniffLength is how far into the data BEGIN:VCALENDAR is expected
+const calendarSniffLength = 4096
+
+// LooksLikeICal reports whether data contains BEGIN:VCALENDAR near its start. The declared content
+// type is not consulted, so calendars served as text/plain or application/octet-stream pass, while
+// HTML documents are rejected even if they mention BEGIN:VCALENDAR, e.g. on a help page.
+func LooksLikeICal(data []byte) bool {
+	if len(data) > calendarSniffLength {
+		data = data[:calendarSniffLength]
+	}
+	return bytes.Contains(bytes.ToUpper(data), []byte("BEGIN:VCALENDAR")) && !startsLikeHTML(data)
+}
+
+// StartsLikeNonCalendar reports whether the first bytes of a body show that it is not iCal data: they
+// lack BEGIN:VCALENDAR and begin like an HTML document or a JSON value. It lets a reader give up on
+// an error page early; data it passes may still fail LooksLikeICal once read in full.
+func StartsLikeNonCalendar(prefix []byte) bool {
+	if bytes.Contains(bytes.ToUpper(prefix), []byte("BEGIN:VCALENDAR")) {
+		return false
+	}
+	if startsLikeHTML(prefix) {
+		return true
+	}
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(prefix, utf8BOM), " \t\r\n")
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+}
+
+// startsLikeHTML reports whether data begins with an HTML doctype or html tag, after any byte
+// order mark and whitespace
+func startsLikeHTML(data []byte) bool {
+	data = bytes.TrimLeft(bytes.TrimPrefix(data, utf8BOM), " \t\r\n")
+	prefix := bytes.ToLower(data[:min(len(data), len("<!doctype html"))])
+	return bytes.HasPrefix(prefix, []byte("<!doctype html")) || bytes.HasPrefix(prefix, []byte("<html"))
+}
+
+// dropPreamble removes anything before the BEGIN:VCALENDAR line, such as notices a server-side
+// script printed before the calendar, which the parser would reject
+func dropPreamble(data []byte, fixLog *FixLog) []byte {
+	for offset := 0; offset < len(data); {
+		line, _, _ := bytes.Cut(data[offset:], []byte("\n"))
+		if bytes.EqualFold(bytes.TrimSpace(line), []byte("BEGIN:VCALENDAR")) {
+			if offset > 0 {
+				fixLog.AddFix(fmt.Sprintf("Dropped %d bytes before BEGIN:VCALENDAR", offset))
+			}
+			return data[offset:]
+		}
+		offset += len(line) + 1
+	}
+	return data
+}
+
+// NonCalendarContentError describes non-calendar data by its content type, sniffing it if unknown
+func NonCalendarContentError(data []byte, contentType string) error {
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	return fmt.Errorf("%w (%s)", ErrNonCalendarContent, contentType)
+}
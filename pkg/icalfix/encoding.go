@@ -0,0 +1,80 @@
+package icalfix
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// utf8BOM is the byte order mark some editors write at the start of UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// latin1Charsets are the charset names treated as Windows-1252, which browsers and most
+// exporters use for Latin-1 as well since it only adds printable characters in 0x80..0x9F
+var latin1Charsets = map[string]bool{
+	"iso-8859-1":   true,
+	"iso8859-1":    true,
+	"latin1":       true,
+	"l1":           true,
+	"windows-1252": true,
+	"cp1252":       true,
+}
+
+// windows1252Specials maps the bytes 0x80..0x9F of Windows-1252 to their characters; the
+// five undefined bytes map to the C1 control characters like in Latin-1
+var windows1252Specials = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008D', 'Ž', '\u008F',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009D', 'ž', 'Ÿ',
+}
+
+// toUTF8 strips a leading UTF-8 byte order mark and transcodes Latin-1 data to UTF-8, since the
+// parser and RFC 5545 expect UTF-8. Data is treated as Latin-1 when charset says so, or when no
+// other charset is given and the data is not valid UTF-8.
+func toUTF8(data []byte, charset string, fixLog *FixLog) []byte {
+	if bytes.HasPrefix(data, utf8BOM) {
+		data = data[len(utf8BOM):]
+		fixLog.AddFix("Removed UTF-8 byte order mark")
+	}
+
+	charset = strings.ToLower(strings.Trim(strings.TrimSpace(charset), `"`))
+	switch {
+	case latin1Charsets[charset]:
+		if isASCII(data) {
+			return data
+		}
+		fixLog.AddFix("Converted " + charset + " data to UTF-8")
+	case charset != "" && charset != "utf-8" && charset != "us-ascii":
+		return data
+	case utf8.Valid(data):
+		return data
+	default:
+		fixLog.AddFix("Converted data that is not valid UTF-8 from Windows-1252 to UTF-8")
+	}
+	return windows1252ToUTF8(data)
+}
+
+// isASCII reports whether data only contains 7-bit characters, which read the same in every supported charset
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// windows1252ToUTF8 transcodes Windows-1252 (and thereby Latin-1) data to UTF-8
+func windows1252ToUTF8(data []byte) []byte {
+	out := make([]byte, 0, len(data)+len(data)/8)
+	for _, b := range data {
+		switch {
+		case b < utf8.RuneSelf:
+			out = append(out, b)
+		case b < 0xA0:
+			out = utf8.AppendRune(out, windows1252Specials[b-0x80])
+		default:
+			out = utf8.AppendRune(out, rune(b))
+		}
+	}
+	return out
+}
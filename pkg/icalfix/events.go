@@ -0,0 +1,90 @@
+package icalfix
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// Event is the JSON form of a processed event. Times are RFC 3339 with the offset of the event's
+// zone, plain dates for all-day events, or the raw value if it cannot be parsed.
+type Event struct {
+	UID          string   `json:"uid"`
+	Summary      string   `json:"summary,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Location     string   `json:"location,omitempty"`
+	Start        string   `json:"start,omitempty"`
+	End          string   `json:"end,omitempty"`
+	AllDay       bool     `json:"all_day,omitempty"`
+	TimeZone     string   `json:"time_zone,omitempty"`
+	Status       string   `json:"status,omitempty"`
+	Categories   []string `json:"categories,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	RRule        string   `json:"rrule,omitempty"`
+	RecurrenceID string   `json:"recurrence_id,omitempty"`
+}
+
+// WalkEvents parses a processed calendar and calls fn with each of its events in order, so that
+// a caller can write them out one at a time. Walking stops at the first error fn returns.
+func WalkEvents(icalData string, fn func(Event) error) error {
+	calendar, err := ics.ParseCalendar(bytes.NewReader(protectCategoryCommas([]byte(icalData))))
+	if err != nil {
+		return fmt.Errorf("invalid iCal format: %w", err)
+	}
+
+	for _, event := range calendar.Events() {
+		if err := fn(eventObject(event)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventObject extracts the JSON form of an event
+func eventObject(event *ics.VEvent) Event {
+	value := func(property ics.ComponentProperty) string {
+		if prop := event.GetProperty(property); prop != nil {
+			return prop.Value
+		}
+		return ""
+	}
+
+	object := Event{
+		UID:          value(ics.ComponentPropertyUniqueId),
+		Summary:      value(ics.ComponentPropertySummary),
+		Description:  value(ics.ComponentPropertyDescription),
+		Location:     value(ics.ComponentPropertyLocation),
+		Start:        eventObjectTime(event.GetProperty(ics.ComponentPropertyDtStart)),
+		End:          eventObjectTime(event.GetProperty(ics.ComponentPropertyDtEnd)),
+		Status:       value(ics.ComponentPropertyStatus),
+		Categories:   eventCategories(event),
+		URL:          value(ics.ComponentPropertyUrl),
+		RRule:        value(ics.ComponentPropertyRrule),
+		RecurrenceID: eventObjectTime(event.GetProperty(ics.ComponentPropertyRecurrenceId)),
+	}
+	if dtstart := event.GetProperty(ics.ComponentPropertyDtStart); dtstart != nil {
+		object.AllDay = isDateValue(dtstart)
+		object.TimeZone = firstParameter(*dtstart, ics.ParameterTzid)
+	}
+	return object
+}
+
+// eventObjectTime formats a date-time property for Event, or returns "" if there is none
+func eventObjectTime(prop *ics.IANAProperty) string {
+	if prop == nil {
+		return ""
+	}
+	t, err := parseEventTime(prop, time.UTC)
+	switch {
+	case err != nil:
+		return prop.Value
+	case isDateValue(prop):
+		return t.Format(time.DateOnly)
+	case isFloatingDateTime(*prop):
+		return t.Format("2006-01-02T15:04:05")
+	default:
+		return t.Format(time.RFC3339)
+	}
+}
@@ -0,0 +1,1613 @@
+// Package icalfix fixes common issues in iCal files and generates RFC 5545 compliant calendar data.
+// It also provides the filters and transformations of the iCal proxy server, so the same
+// processing can be used from other Go programs. Start with Process and the With... options,
+// or fill in ProcessOptions and call ProcessICalDataWithOptions.
+//
+// This file contains functions for fixing common issues in iCal files.
+package icalfix
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// FixLog tracks which fixes have been applied to an iCal file
+type FixLog struct {
+	Fixes []string
+	// Entries lists every fix individually together with the component it was applied to
+	Entries []FixEntry
+	// Warnings lists problems that processing worked around without fixing them, such as an event
+	// whose date could not be parsed; the rest of the calendar is still processed
+	Warnings []string
+}
+
+// FixEntry is a single applied fix. Component and Index (1-based) are empty for calendar-level fixes.
+type FixEntry struct {
+	Component string `json:"component,omitempty"`
+	Index     int    `json:"index,omitempty"`
+	Fix       string `json:"fix"`
+}
+
+// AddFix records a fix that was applied
+func (fl *FixLog) AddFix(fix string) {
+	fl.Fixes = append(fl.Fixes, fix)
+	fl.Entries = append(fl.Entries, FixEntry{Fix: fix})
+	log.Printf("Applied fix: %s", fix)
+}
+
+// AddComponentFixes records the fixes applied to the index-th component of a kind such as "Event"
+func (fl *FixLog) AddComponentFixes(component string, index int, fixes []string) {
+	if len(fixes) == 0 {
+		return
+	}
+	summary := fmt.Sprintf("%s %d: %s", component, index, strings.Join(fixes, ", "))
+	fl.Fixes = append(fl.Fixes, summary)
+	for _, fix := range fixes {
+		fl.Entries = append(fl.Entries, FixEntry{Component: component, Index: index, Fix: fix})
+	}
+	log.Printf("Applied fix: %s", summary)
+}
+
+// AddWarning records a non-fatal problem that was not fixed
+func (fl *FixLog) AddWarning(warning string) {
+	fl.Warnings = append(fl.Warnings, warning)
+	log.Printf("Warning: %s", warning)
+}
+
+// Prepend places the fixes and warnings of earlier steps before the ones recorded so far
+func (fl *FixLog) Prepend(earlier *FixLog) {
+	fl.Fixes = append(append([]string(nil), earlier.Fixes...), fl.Fixes...)
+	fl.Entries = append(append([]FixEntry(nil), earlier.Entries...), fl.Entries...)
+	fl.Warnings = append(append([]string(nil), earlier.Warnings...), fl.Warnings...)
+}
+
+// GetSummary returns a summary of all fixes applied
+func (fl *FixLog) GetSummary() string {
+	if len(fl.Fixes) == 0 {
+		return "No fixes applied"
+	}
+	return fmt.Sprintf("Applied %d fixes:\n %s", len(fl.Fixes), strings.Join(fl.Fixes, "\n"))
+}
+
+// DefaultProdID is the PRODID added to calendars that lack one.
+// The server sets it from DEFAULT_PRODID for self-hosters who want their own branding.
+var DefaultProdID = "-//iCal Proxy Server//EN"
+
+// DefaultSummary is the SUMMARY added to events that have neither a title nor a DESCRIPTION to
+// derive one from. The server sets it from DEFAULT_SUMMARY.
+var DefaultSummary = "Event"
+
+// nowFunc returns the current time for the timestamps and defaults the fixes add and for the
+// upcoming filter. Tests replace it with SetClock to get deterministic output.
+var nowFunc = time.Now
+
+// Now returns the current time of the clock used by processing, so that callers resolving relative
+// dates, like the server's date windows, agree with the upcoming filter and the added timestamps
+func Now() time.Time {
+	return nowFunc()
+}
+
+// SetClock replaces the clock returned by Now and used by processing, or restores time.Now if now
+// is nil. It returns a function that restores the previous clock. It is meant for tests and must not
+// be called while calendars are processed.
+func SetClock(now func() time.Time) (restore func()) {
+	previous := nowFunc
+	if now == nil {
+		now = time.Now
+	}
+	nowFunc = now
+	return func() { nowFunc = previous }
+}
+
+// FormatProdID validates a custom PRODID and wraps plain names like "My Proxy" as "-//My Proxy//EN"
+func FormatProdID(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("PRODID must not be empty")
+	}
+	if strings.IndexFunc(value, unicode.IsControl) != -1 {
+		return "", fmt.Errorf("PRODID must not contain control characters")
+	}
+	if strings.HasPrefix(value, "-//") || strings.HasPrefix(value, "+//") {
+		return value, nil
+	}
+	return "-//" + value + "//EN", nil
+}
+
+// FormatEventStatus validates an event STATUS, which must be TENTATIVE, CONFIRMED, CANCELLED, or an
+// X- name, and returns it in upper case
+func FormatEventStatus(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	invalidRune := func(r rune) bool {
+		return (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-'
+	}
+	if !isValidStatusValue(ics.ComponentVEvent, value) || len(value) == len("X-") || strings.IndexFunc(value, invalidRune) != -1 {
+		return "", fmt.Errorf("invalid event STATUS '%s'", value)
+	}
+	return strings.ToUpper(value), nil
+}
+
+// FixOptions adjusts the fixes applied by FixCalendar. The zero value applies all fixes.
+type FixOptions struct {
+	// ApplyCalendarTZ interprets floating event times in the zone given by X-WR-TIMEZONE
+	ApplyCalendarTZ bool
+	// SkipOptionalProperties does not add missing optional event properties (CREATED, LAST-MODIFIED,
+	// CLASS, STATUS, TRANSP); invalid values of existing ones are still fixed
+	SkipOptionalProperties bool
+	// Disable lists event fixes to skip by their identifier in EventFixes, e.g. "dtend" for a feed of
+	// instantaneous events; unknown identifiers are ignored with a warning
+	Disable []string
+	// Summary replaces DefaultSummary for events without SUMMARY and DESCRIPTION; empty uses DefaultSummary
+	Summary string
+	// StableUID derives missing event UIDs from SUMMARY, DTSTART, and DTEND instead of generating
+	// random ones, so an event keeps its UID across refreshes of the feed
+	StableUID bool
+}
+
+// EventFixes lists the identifiers of the event fixes that FixOptions.Disable can skip. Each one
+// covers every fix of the property or component it is named after.
+var EventFixes = []string{
+	"uid", "dtstamp", "summary", "dtstart", "dtend",
+	"created", "last-modified", "class", "status", "transp", "geo", "priority", "sequence",
+	"participants", "attach", "categories", "alarms",
+}
+
+// enabled reports whether the event fix with the given identifier from EventFixes should be applied
+func (opts FixOptions) enabled(fix string) bool {
+	return !slices.Contains(opts.Disable, fix)
+}
+
+// Comprehensive calendar fixing function that addresses common RFC 5545 compliance issues.
+func FixCalendar(calendar *ics.Calendar, opts FixOptions) *FixLog {
+	fixLog := &FixLog{}
+
+	// Fix calendar-level properties
+	fixCalendarProperties(calendar, fixLog)
+
+	calendarTZ := ""
+	if opts.ApplyCalendarTZ {
+		calendarTZ = calendarTimezone(calendar, fixLog)
+	}
+
+	for _, fix := range opts.Disable {
+		if !slices.Contains(EventFixes, fix) {
+			fixLog.AddWarning(fmt.Sprintf("Ignored unknown fix '%s' in disable list", fix))
+		}
+	}
+
+	// Fix all events; stable UIDs are assigned first, so fixEvent does not generate a random one
+	stableUIDs := make(map[string]int)
+	for i, event := range calendar.Events() {
+		var fixes []string
+		if opts.StableUID && opts.enabled("uid") && event.GetProperty(ics.ComponentPropertyUniqueId) == nil {
+			event.SetProperty(ics.ComponentPropertyUniqueId, stableUID(event, stableUIDs))
+			fixes = append(fixes, "Generated missing UID from SUMMARY, DTSTART, and DTEND")
+		}
+		fixLog.AddComponentFixes("Event", i+1, append(fixes, fixEvent(event, calendarTZ, opts).Fixes...))
+		for _, warning := range eventDateWarnings(event) {
+			fixLog.AddWarning(fmt.Sprintf("Event %d: %s", i+1, warning))
+		}
+	}
+
+	// Fix all todos
+	for i, todo := range calendar.Todos() {
+		fixLog.AddComponentFixes("Todo", i+1, fixTodo(todo).Fixes)
+	}
+
+	// Fix all journal entries
+	journals := 0
+	for _, component := range calendar.Components {
+		if journal, ok := component.(*ics.VJournal); ok {
+			journals++
+			fixLog.AddComponentFixes("Journal", journals, fixJournal(journal).Fixes)
+		}
+	}
+
+	// Runs last, so a time zone added by any of the fixes above is deduplicated as well
+	removeDuplicateTimezones(calendar, fixLog)
+
+	return fixLog
+}
+
+// removeDuplicateTimezones keeps one VTIMEZONE per TZID, since some clients reject calendars that
+// define a zone twice. The most complete definition takes the place of the first one.
+func removeDuplicateTimezones(calendar *ics.Calendar, fixLog *FixLog) {
+	best := make(map[string]*ics.VTimezone)
+	for _, component := range calendar.Components {
+		timezone, ok := component.(*ics.VTimezone)
+		if !ok {
+			continue
+		}
+		tzid := timezoneID(timezone)
+		if current, seen := best[tzid]; tzid != "" && (!seen || moreCompleteTimezone(timezone, current)) {
+			best[tzid] = timezone
+		}
+	}
+
+	removed := 0
+	placed := make(map[string]bool)
+	components := make([]ics.Component, 0, len(calendar.Components))
+	for _, component := range calendar.Components {
+		timezone, ok := component.(*ics.VTimezone)
+		if !ok || timezoneID(timezone) == "" {
+			components = append(components, component)
+			continue
+		}
+		tzid := timezoneID(timezone)
+		if placed[tzid] {
+			removed++
+			continue
+		}
+		placed[tzid] = true
+		components = append(components, best[tzid])
+	}
+
+	if removed > 0 {
+		calendar.Components = components
+		fixLog.AddFix(fmt.Sprintf("Removed %d duplicate VTIMEZONE components", removed))
+	}
+}
+
+// timezoneID returns the TZID of a VTIMEZONE, or "" if it has none
+func timezoneID(timezone *ics.VTimezone) string {
+	if prop := timezone.GetProperty(ics.ComponentPropertyTzid); prop != nil {
+		return strings.TrimSpace(prop.Value)
+	}
+	return ""
+}
+
+// moreCompleteTimezone reports whether a defines more STANDARD/DAYLIGHT rules than b, or as many
+// rules and more properties
+func moreCompleteTimezone(a, b *ics.VTimezone) bool {
+	if len(a.Components) != len(b.Components) {
+		return len(a.Components) > len(b.Components)
+	}
+	return len(a.Properties) > len(b.Properties)
+}
+
+func fixCalendarProperties(calendar *ics.Calendar, fixLog *FixLog) {
+	// Helper function to get calendar property value
+	getCalendarProperty := func(propertyName string) string {
+		for _, prop := range calendar.CalendarProperties {
+			if prop.IANAToken == propertyName {
+				return prop.Value
+			}
+		}
+		return ""
+	}
+
+	// Ensure VERSION is set correctly
+	if getCalendarProperty("VERSION") != "2.0" {
+		calendar.SetVersion("2.0")
+		fixLog.AddFix("Set VERSION to 2.0")
+	}
+
+	// Ensure PRODID exists (RFC 5545: required property)
+	// Only set our own if missing entirely - preserve existing valid PRODID
+	if getCalendarProperty("PRODID") == "" {
+		calendar.SetProductId(DefaultProdID)
+		fixLog.AddFix("Added missing PRODID")
+	}
+
+	// Set CALSCALE if not present or invalid (RFC 5545: default is GREGORIAN, only GREGORIAN is widely supported)
+	calscale := getCalendarProperty("CALSCALE")
+	if calscale == "" {
+		calendar.SetCalscale("GREGORIAN")
+		fixLog.AddFix("Added missing CALSCALE (GREGORIAN)")
+	} else if calscale != "GREGORIAN" {
+		// RFC 5545 allows other calendar scales, but GREGORIAN is the only widely supported one
+		calendar.SetCalscale("GREGORIAN")
+		fixLog.AddFix(fmt.Sprintf("Changed unsupported CALSCALE '%s' to GREGORIAN", calscale))
+	}
+}
+
+// calendarTimezone returns the IANA name of a calendar's X-WR-TIMEZONE if it names a known zone, or ""
+func calendarTimezone(calendar *ics.Calendar, fixLog *FixLog) string {
+	for _, prop := range calendar.CalendarProperties {
+		if prop.IANAToken != string(ics.PropertyXWRTimezone) {
+			continue
+		}
+		tzid := ianaTimezone(prop.Value)
+		if _, err := time.LoadLocation(tzid); tzid == "" || err != nil {
+			fixLog.AddWarning(fmt.Sprintf("Ignored unknown X-WR-TIMEZONE '%s'", prop.Value))
+			return ""
+		}
+		return tzid
+	}
+	return ""
+}
+
+// eventDateWarnings describes the DTSTART and DTEND values of an event that are still unparseable
+// after the fixes. Such events are kept, but date filters and time-based transforms pass them by.
+func eventDateWarnings(event *ics.VEvent) []string {
+	var warnings []string
+	for _, property := range []ics.ComponentProperty{ics.ComponentPropertyDtStart, ics.ComponentPropertyDtEnd} {
+		prop := event.GetProperty(property)
+		if prop == nil {
+			continue
+		}
+		if _, err := parseEventTime(prop, time.UTC); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Unparseable %s '%s' left as is", property, prop.Value))
+		}
+	}
+	return warnings
+}
+
+// fixEvent fixes a single event; calendarTZ is attached to floating DTSTART and DTEND values unless empty
+func fixEvent(event *ics.VEvent, calendarTZ string, opts FixOptions) *FixLog {
+	fixLog := &FixLog{}
+
+	// Fix required properties
+	fixRequiredEventProperties(event, opts, fixLog)
+
+	// Fix date-time properties
+	fixEventDateTimes(event, calendarTZ, opts, fixLog)
+
+	// Fix optional but commonly expected properties
+	fixEventOptionalProperties(event, opts, fixLog)
+
+	// Fix calendar user addresses
+	if opts.enabled("participants") {
+		fixEventParticipants(event, fixLog)
+	}
+
+	// Fix attachments
+	if opts.enabled("attach") {
+		fixEventAttachments(event, fixLog)
+	}
+
+	// Fix categories
+	if opts.enabled("categories") {
+		fixEventCategories(event, fixLog)
+	}
+
+	// Fix nested components (alarms)
+	if opts.enabled("alarms") {
+		fixEventAlarms(event, fixLog)
+	}
+
+	return fixLog
+}
+
+func fixRequiredEventProperties(event *ics.VEvent, opts FixOptions, fixLog *FixLog) {
+	// Ensure UID exists
+	if opts.enabled("uid") && event.GetProperty(ics.ComponentPropertyUniqueId) == nil {
+		uid := generateUID()
+		event.SetProperty(ics.ComponentPropertyUniqueId, uid)
+		fixLog.AddFix("Generated missing UID")
+	}
+
+	// Ensure DTSTAMP exists and is a plausible UTC date-time, since some clients reject the event otherwise
+	if opts.enabled("dtstamp") {
+		now := nowFunc().UTC()
+		if dtstamp := event.GetProperty(ics.ComponentPropertyDtstamp); dtstamp == nil {
+			event.SetProperty(ics.ComponentPropertyDtstamp, now.Format("20060102T150405Z"))
+			fixLog.AddFix("Added missing DTSTAMP")
+		} else {
+			fixDtstamp(dtstamp, now, fixLog)
+		}
+	}
+
+	// Ensure SUMMARY exists (required for display)
+	// Prefer the start of DESCRIPTION over a generic default, since it usually says what the event is
+	if opts.enabled("summary") && event.GetProperty(ics.ComponentPropertySummary) == nil {
+		description := event.GetProperty(ics.ComponentPropertyDescription)
+		if summary := summaryFromDescription(description); summary != "" {
+			event.SetProperty(ics.ComponentPropertySummary, summary)
+			fixLog.AddFix("Derived missing SUMMARY from DESCRIPTION")
+		} else {
+			// The serializer escapes the text, so commas and semicolons in the default are safe
+			title := opts.Summary
+			if title == "" {
+				title = DefaultSummary
+			}
+			event.SetProperty(ics.ComponentPropertySummary, title)
+			fixLog.AddFix("Added default SUMMARY")
+		}
+	}
+}
+
+// maxDtstampFutureSkew is how far in the future a DTSTAMP may lie before it is considered invalid.
+// It allows for clock skew between the feed's server and the proxy.
+const maxDtstampFutureSkew = 24 * time.Hour
+
+// fixDtstamp replaces a DTSTAMP that cannot be parsed or lies in the future with now and normalizes its format
+func fixDtstamp(dtstamp *ics.IANAProperty, now time.Time, fixLog *FixLog) {
+	normalized := normalizeDateTime(dtstamp.Value)
+	stamp, err := time.Parse("20060102T150405Z", normalized)
+	switch {
+	case err != nil:
+		fixLog.AddFix(fmt.Sprintf("Replaced invalid DTSTAMP '%s'", dtstamp.Value))
+		dtstamp.Value = now.Format("20060102T150405Z")
+	case stamp.After(now.Add(maxDtstampFutureSkew)):
+		fixLog.AddFix(fmt.Sprintf("Replaced future DTSTAMP '%s'", dtstamp.Value))
+		dtstamp.Value = now.Format("20060102T150405Z")
+	case normalized != dtstamp.Value:
+		dtstamp.Value = normalized
+		fixLog.AddFix("Normalized DTSTAMP format")
+	}
+}
+
+// maxDerivedSummaryLength is the maximum length in characters of a SUMMARY derived from DESCRIPTION
+const maxDerivedSummaryLength = 60
+
+// summaryFromDescription returns the first line or sentence of a DESCRIPTION, truncated at a word
+// boundary to maxDerivedSummaryLength characters, or "" if there is no usable text
+func summaryFromDescription(description *ics.IANAProperty) string {
+	if description == nil {
+		return ""
+	}
+
+	text := strings.TrimSpace(description.Value)
+	if end := strings.IndexAny(text, "\r\n"); end != -1 {
+		text = text[:end]
+	}
+	for _, terminator := range []string{". ", "! ", "? "} {
+		if end := strings.Index(text, terminator); end != -1 {
+			text = text[:end+1]
+		}
+	}
+	text = strings.TrimSpace(text)
+
+	runes := []rune(text)
+	if len(runes) <= maxDerivedSummaryLength {
+		return text
+	}
+	truncated := string(runes[:maxDerivedSummaryLength])
+	if space := strings.LastIndex(truncated, " "); space > 0 {
+		truncated = truncated[:space]
+	}
+	return strings.TrimRight(truncated, " ,;:-") + "..."
+}
+
+func fixEventDateTimes(event *ics.VEvent, calendarTZ string, opts FixOptions, fixLog *FixLog) {
+	dtstart := event.GetProperty(ics.ComponentPropertyDtStart)
+	dtend := event.GetProperty(ics.ComponentPropertyDtEnd)
+
+	// Interpret floating times in the calendar's default zone
+	if calendarTZ != "" {
+		for _, prop := range []*ics.IANAProperty{dtstart, dtend} {
+			if prop != nil && isFloatingDateTime(*prop) {
+				setParameter(prop, ics.ParameterTzid, calendarTZ)
+				fixLog.AddFix(fmt.Sprintf("Applied X-WR-TIMEZONE %s to floating %s", calendarTZ, prop.IANAToken))
+			}
+		}
+	}
+
+	// Ensure DTSTART exists
+	if opts.enabled("dtstart") && dtstart == nil {
+		// Create a default start time (now)
+		now := nowFunc().UTC().Format("20060102T150405Z")
+		event.SetProperty(ics.ComponentPropertyDtStart, now)
+		dtstart = event.GetProperty(ics.ComponentPropertyDtStart)
+		fixLog.AddFix("Added missing DTSTART")
+	}
+
+	// Date values cannot carry a TZID
+	if opts.enabled("dtstart") {
+		fixDateWithTzid(dtstart, fixLog)
+	}
+	if opts.enabled("dtend") {
+		fixDateWithTzid(dtend, fixLog)
+	}
+
+	// Fix DTSTART format
+	if opts.enabled("dtstart") && dtstart != nil {
+		originalValue := dtstart.Value
+		dtstart.Value = normalizeDateTimeProperty(*dtstart)
+		if originalValue != dtstart.Value {
+			fixLog.AddFix("Normalized DTSTART format")
+		}
+	}
+
+	// The remaining fixes all change DTEND
+	if !opts.enabled("dtend") {
+		return
+	}
+
+	// Ensure DTEND exists and is after DTSTART
+	if dtend == nil && dtstart != nil {
+		// Create DTEND 1 hour after DTSTART, or the next day for all-day events
+		startTime, err := parseDateTime(dtstart.Value)
+		if err == nil && isDateValue(dtstart) {
+			event.SetProperty(ics.ComponentPropertyDtEnd, startTime.AddDate(0, 0, 1).Format("20060102"), ics.WithValue(string(ics.ValueDataTypeDate)))
+		} else if tzid := firstParameter(*dtstart, ics.ParameterTzid); err == nil && tzid != "" {
+			// Local start times get a local end time in the same zone
+			endTime := startTime.Add(time.Hour)
+			event.SetProperty(ics.ComponentPropertyDtEnd, endTime.Format("20060102T150405"), ics.WithTZID(tzid))
+		} else if err == nil {
+			endTime := startTime.Add(time.Hour)
+			event.SetProperty(ics.ComponentPropertyDtEnd, endTime.UTC().Format("20060102T150405Z"))
+		} else {
+			// Fallback: use current time + 1 hour
+			endTime := nowFunc().Add(time.Hour).UTC().Format("20060102T150405Z")
+			event.SetProperty(ics.ComponentPropertyDtEnd, endTime)
+		}
+		dtend = event.GetProperty(ics.ComponentPropertyDtEnd)
+		fixLog.AddFix("Added missing DTEND")
+	}
+
+	// Fix DTEND format
+	if dtend != nil {
+		originalValue := dtend.Value
+		dtend.Value = normalizeDateTimeProperty(*dtend)
+		if originalValue != dtend.Value {
+			fixLog.AddFix("Normalized DTEND format")
+		}
+	}
+
+	// The DTEND of an all-day event is exclusive, so a single-day event ends on the next day.
+	// Feeds often repeat DTSTART instead, which clients render as zero-length.
+	if dtstart != nil && dtend != nil && isDateValue(dtstart) && isDateValue(dtend) {
+		startDate, startErr := parseDateTime(dtstart.Value)
+		endDate, endErr := parseDateTime(dtend.Value)
+		if startErr == nil && endErr == nil && !endDate.After(startDate) {
+			dtend.Value = startDate.AddDate(0, 0, 1).Format("20060102")
+			fixLog.AddFix("Fixed all-day DTEND to be the day after DTSTART")
+		}
+		return
+	}
+
+	// Ensure DTEND is after DTSTART, comparing instants in each property's zone so that local
+	// times around a DST transition or a UTC end for a local start are not wrongly flipped
+	if dtstart != nil && dtend != nil {
+		startTime, startErr := parseEventTime(dtstart, time.UTC)
+		endTime, endErr := parseEventTime(dtend, time.UTC)
+
+		if startErr == nil && endErr == nil && !endTime.After(startTime) {
+			// Fix by adding 1 hour to start time
+			newEndTime := startTime.Add(time.Hour)
+			if firstParameter(*dtend, ics.ParameterTzid) != "" {
+				dtend.Value = newEndTime.In(endTime.Location()).Format("20060102T150405")
+			} else {
+				dtend.Value = newEndTime.UTC().Format("20060102T150405Z")
+			}
+			fixLog.AddFix("Fixed DTEND to be after DTSTART")
+		}
+	}
+}
+
+func fixEventOptionalProperties(event *ics.VEvent, opts FixOptions, fixLog *FixLog) {
+	addMissing := !opts.SkipOptionalProperties
+
+	// Add CREATED timestamp if missing
+	if addMissing && opts.enabled("created") && event.GetProperty(ics.ComponentPropertyCreated) == nil {
+		now := nowFunc().UTC().Format("20060102T150405Z")
+		event.SetProperty(ics.ComponentPropertyCreated, now)
+		fixLog.AddFix("Added missing CREATED timestamp")
+	}
+
+	// Add LAST-MODIFIED timestamp if missing
+	if addMissing && opts.enabled("last-modified") && event.GetProperty(ics.ComponentPropertyLastModified) == nil {
+		now := nowFunc().UTC().Format("20060102T150405Z")
+		event.SetProperty(ics.ComponentPropertyLastModified, now)
+		fixLog.AddFix("Added missing LAST-MODIFIED timestamp")
+	}
+
+	// Validate and fix CLASS property (RFC 5545: "PUBLIC" / "PRIVATE" / "CONFIDENTIAL" / iana-token / x-name)
+	if opts.enabled("class") {
+		class := event.GetProperty(ics.ComponentPropertyClass)
+		if class == nil {
+			if addMissing {
+				event.SetProperty(ics.ComponentPropertyClass, "PUBLIC")
+				fixLog.AddFix("Added missing CLASS (PUBLIC)")
+			}
+		} else if class.Value != "" && !isValidClassValue(class.Value) {
+			fixLog.AddFix(fmt.Sprintf("Invalid CLASS value '%s', changed to PUBLIC", class.Value))
+			class.Value = "PUBLIC"
+		}
+	}
+
+	// Validate and fix STATUS property (RFC 5545: "TENTATIVE" / "CONFIRMED" / "CANCELLED" / iana-token / x-name)
+	if opts.enabled("status") {
+		status := event.GetProperty(ics.ComponentPropertyStatus)
+		if status == nil {
+			if addMissing {
+				event.SetProperty(ics.ComponentPropertyStatus, "CONFIRMED")
+				fixLog.AddFix("Added missing STATUS (CONFIRMED)")
+			}
+		} else if status.Value == "" {
+			status.Value = "CONFIRMED"
+			fixLog.AddFix("Set empty STATUS to CONFIRMED")
+		} else if !isValidStatusValue(ics.ComponentVEvent, status.Value) {
+			fixLog.AddFix(fmt.Sprintf("Invalid STATUS value '%s', changed to CONFIRMED", status.Value))
+			status.Value = "CONFIRMED"
+		}
+	}
+
+	// Validate and fix TRANSP property (RFC 5545: "OPAQUE" / "TRANSPARENT" / iana-token / x-name)
+	if opts.enabled("transp") {
+		transp := event.GetProperty(ics.ComponentPropertyTransp)
+		if transp == nil {
+			if addMissing {
+				event.SetProperty(ics.ComponentPropertyTransp, "OPAQUE")
+				fixLog.AddFix("Added missing TRANSP (OPAQUE)")
+			}
+		} else if transp.Value == "" {
+			transp.Value = "OPAQUE"
+			fixLog.AddFix("Set empty TRANSP to OPAQUE")
+		} else if !isValidTranspValue(transp.Value) {
+			fixLog.AddFix(fmt.Sprintf("Invalid TRANSP value '%s', changed to OPAQUE", transp.Value))
+			transp.Value = "OPAQUE"
+		}
+	}
+
+	// Validate and fix GEO property (RFC 5545: latitude ";" longitude); never invent coordinates
+	if opts.enabled("geo") {
+		if geo := event.GetProperty(ics.ComponentPropertyGeo); geo != nil && !isValidGeoValue(geo.Value) {
+			if repaired, ok := repairGeoValue(geo.Value); ok {
+				fixLog.AddFix(fmt.Sprintf("Repaired GEO value '%s' to '%s'", geo.Value, repaired))
+				geo.Value = repaired
+			} else {
+				fixLog.AddFix(fmt.Sprintf("Removed invalid GEO value '%s'", geo.Value))
+				event.RemoveProperty(ics.ComponentPropertyGeo)
+			}
+		}
+	}
+
+	// Validate PRIORITY; it is optional, so a missing one is not added
+	if opts.enabled("priority") {
+		fixPriority(&event.ComponentBase, fixLog)
+	}
+
+	// Validate SEQUENCE; a missing one is not added either
+	if opts.enabled("sequence") {
+		fixSequence(&event.ComponentBase, fixLog)
+	}
+}
+
+// fixPriority validates PRIORITY (RFC 5545: integer between 0 and 9), clamping out-of-range
+// values and removing non-numeric ones
+func fixPriority(component *ics.ComponentBase, fixLog *FixLog) {
+	priority := component.GetProperty(ics.ComponentPropertyPriority)
+	if priority == nil {
+		return
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(priority.Value))
+	switch {
+	case err != nil:
+		fixLog.AddFix(fmt.Sprintf("Removed non-numeric PRIORITY '%s'", priority.Value))
+		component.RemoveProperty(ics.ComponentPropertyPriority)
+	case value < 0:
+		fixLog.AddFix(fmt.Sprintf("Clamped PRIORITY %d to 0", value))
+		priority.Value = "0"
+	case value > 9:
+		fixLog.AddFix(fmt.Sprintf("Clamped PRIORITY %d to 9", value))
+		priority.Value = "9"
+	}
+}
+
+// fixSequence validates SEQUENCE (RFC 5545: non-negative integer), replacing empty, negative,
+// and non-numeric values with 0 so that clients comparing revisions see the initial one
+func fixSequence(component *ics.ComponentBase, fixLog *FixLog) {
+	sequence := component.GetProperty(ics.ComponentPropertySequence)
+	if sequence == nil {
+		return
+	}
+
+	if value, err := strconv.Atoi(strings.TrimSpace(sequence.Value)); err != nil || value < 0 {
+		fixLog.AddFix(fmt.Sprintf("Invalid SEQUENCE value '%s', changed to 0", sequence.Value))
+		sequence.Value = "0"
+	}
+}
+
+// repairGeoValue rewrites coordinates separated by a comma, whitespace, or a padded semicolon
+// (e.g. "52.5,13.4" or "52.5; 13.4") into the RFC 5545 form "52.5;13.4"
+func repairGeoValue(value string) (string, bool) {
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	})
+	if len(parts) != 2 {
+		return "", false
+	}
+	if _, _, ok := parseGeoCoordinates(parts[0], parts[1]); !ok {
+		return "", false
+	}
+	return parts[0] + ";" + parts[1], true
+}
+
+func fixEventParticipants(event *ics.VEvent, fixLog *FixLog) {
+	// ORGANIZER and ATTENDEE values are cal-addresses (RFC 5545: URI, usually "mailto:")
+	// Only the value is touched so parameters such as CN and ROLE are preserved
+	for i := range event.Properties {
+		prop := &event.Properties[i]
+		if prop.IANAToken != string(ics.ComponentPropertyOrganizer) && prop.IANAToken != string(ics.ComponentPropertyAttendee) {
+			continue
+		}
+		if isBareEmailAddress(prop.Value) {
+			fixLog.AddFix(fmt.Sprintf("Added mailto: prefix to %s '%s'", prop.IANAToken, prop.Value))
+			prop.Value = "mailto:" + prop.Value
+		}
+	}
+}
+
+// isBareEmailAddress reports whether a value looks like an email address without a URI scheme
+func isBareEmailAddress(value string) bool {
+	if strings.ContainsAny(value, ": \t") {
+		return false
+	}
+	at := strings.Index(value, "@")
+	return at > 0 && at == strings.LastIndex(value, "@") && strings.Contains(value[at+1:], ".")
+}
+
+func fixEventCategories(event *ics.VEvent, fixLog *FixLog) {
+	// Merge all CATEGORIES into one comma-separated property, since some clients only read the first one.
+	// Duplicates are matched case-insensitively and the first spelling is kept.
+	props := event.GetProperties(ics.ComponentPropertyCategories)
+	if len(props) == 0 {
+		return
+	}
+
+	var categories []string
+	seen := make(map[string]bool)
+	for _, category := range eventCategories(event) {
+		if key := strings.ToLower(category); !seen[key] {
+			seen[key] = true
+			categories = append(categories, category)
+		}
+	}
+	canonical := joinCategories(categories)
+	if len(props) == 1 && props[0].Value == canonical {
+		return
+	}
+
+	// The merged list replaces the first CATEGORIES property so its parameters (e.g. LANGUAGE) are kept
+	properties := event.Properties[:0]
+	merged := false
+	for _, prop := range event.Properties {
+		if prop.IANAToken == string(ics.ComponentPropertyCategories) {
+			if merged || canonical == "" {
+				continue
+			}
+			prop.Value = canonical
+			merged = true
+		}
+		properties = append(properties, prop)
+	}
+	event.Properties = properties
+
+	logged := strings.ReplaceAll(canonical, escapedCategoryComma, "\\,")
+	switch {
+	case canonical == "":
+		fixLog.AddFix("Removed empty CATEGORIES")
+	case len(props) > 1:
+		fixLog.AddFix(fmt.Sprintf("Merged %d CATEGORIES properties into '%s'", len(props), logged))
+	default:
+		fixLog.AddFix(fmt.Sprintf("Normalized CATEGORIES to '%s'", logged))
+	}
+}
+
+func fixEventAttachments(event *ics.VEvent, fixLog *FixLog) {
+	// ATTACH is a URI, or inline binary data with ENCODING=BASE64 and VALUE=BINARY (RFC 5545 section 3.8.1.1)
+	properties := event.Properties[:0]
+	for _, prop := range event.Properties {
+		if prop.IANAToken != string(ics.ComponentPropertyAttach) {
+			properties = append(properties, prop)
+			continue
+		}
+
+		encoding := strings.ToUpper(firstParameter(prop, ics.ParameterEncoding))
+		valueType := strings.ToUpper(firstParameter(prop, ics.ParameterValue))
+		inline := encoding == "BASE64" || valueType == "BINARY"
+
+		switch {
+		case inline && (valueType == "URI" || (encoding != "" && encoding != "BASE64")):
+			fixLog.AddFix(fmt.Sprintf("Removed ATTACH with inconsistent ENCODING=%s and VALUE=%s", encoding, valueType))
+			continue
+		case inline && !isBase64Value(prop.Value):
+			fixLog.AddFix("Removed ATTACH declared as BASE64 with invalid data")
+			continue
+		case inline:
+			if encoding == "" || valueType == "" {
+				setAttachmentBinaryParameters(&prop)
+				fixLog.AddFix("Added ENCODING=BASE64 and VALUE=BINARY to inline ATTACH")
+			}
+		case isAttachmentURI(prop.Value):
+		case isBase64Value(prop.Value):
+			setAttachmentBinaryParameters(&prop)
+			fixLog.AddFix("Added ENCODING=BASE64 and VALUE=BINARY to inline ATTACH")
+		default:
+			fixLog.AddFix(fmt.Sprintf("Removed ATTACH with malformed URI '%s'", prop.Value))
+			continue
+		}
+		properties = append(properties, prop)
+	}
+	event.Properties = properties
+}
+
+// firstParameter returns the first value of a property parameter, or "" if it is not set
+func firstParameter(prop ics.IANAProperty, parameter ics.Parameter) string {
+	if values := prop.ICalParameters[string(parameter)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// setParameter replaces all values of a property parameter with value
+func setParameter(prop *ics.IANAProperty, parameter ics.Parameter, value string) {
+	if prop.ICalParameters == nil {
+		prop.ICalParameters = map[string][]string{}
+	}
+	prop.ICalParameters[string(parameter)] = []string{value}
+}
+
+// setAttachmentBinaryParameters marks an ATTACH as inline base64 binary data
+func setAttachmentBinaryParameters(prop *ics.IANAProperty) {
+	if prop.ICalParameters == nil {
+		prop.ICalParameters = map[string][]string{}
+	}
+	prop.ICalParameters[string(ics.ParameterEncoding)] = []string{"BASE64"}
+	prop.ICalParameters[string(ics.ParameterValue)] = []string{"BINARY"}
+}
+
+// isAttachmentURI reports whether an ATTACH value is an absolute URI such as https://... or cid:...
+func isAttachmentURI(value string) bool {
+	parsed, err := url.Parse(value)
+	if err != nil || !parsed.IsAbs() {
+		return false
+	}
+	// Hierarchical URIs need a host, e.g. "https:/path" is malformed
+	if strings.HasPrefix(parsed.Scheme, "http") || parsed.Scheme == "ftp" {
+		return parsed.Host != ""
+	}
+	return parsed.Opaque != "" || parsed.Path != "" || parsed.Host != ""
+}
+
+// isBase64Value reports whether a value is non-empty standard base64 data
+func isBase64Value(value string) bool {
+	if value == "" || len(value)%4 != 0 {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(value)
+	return err == nil
+}
+
+func fixEventAlarms(event *ics.VEvent, fixLog *FixLog) {
+	// Fix existing alarms
+	alarmCount := 0
+	for _, alarm := range event.Alarms() {
+		alarmCount++
+
+		// Validate and fix ACTION property (RFC 5545: required, "AUDIO" / "DISPLAY" / "EMAIL" / iana-token / x-name)
+		action := alarm.GetProperty(ics.ComponentPropertyAction)
+		if action == nil {
+			alarm.SetProperty(ics.ComponentPropertyAction, "DISPLAY")
+			fixLog.AddFix(fmt.Sprintf("Added missing ACTION to alarm %d", alarmCount))
+		} else if action.Value == "" {
+			action.Value = "DISPLAY"
+			fixLog.AddFix(fmt.Sprintf("Set empty ACTION to DISPLAY in alarm %d", alarmCount))
+		} else if !isValidActionValue(action.Value) {
+			fixLog.AddFix(fmt.Sprintf("Invalid ACTION value '%s' in alarm %d, changed to DISPLAY", action.Value, alarmCount))
+			action.Value = "DISPLAY"
+		}
+
+		// Ensure TRIGGER property exists (RFC 5545: required)
+		if alarm.GetProperty(ics.ComponentPropertyTrigger) == nil {
+			alarm.SetProperty(ics.ComponentPropertyTrigger, "-PT15M") // 15 minutes before
+			fixLog.AddFix(fmt.Sprintf("Added missing TRIGGER to alarm %d", alarmCount))
+		}
+		fixTriggerRelated(alarm, event, alarmCount, fixLog)
+		fixFloatingTrigger(alarm, event, alarmCount, fixLog)
+		fixAlarmRepeat(alarm, alarmCount, fixLog)
+
+		// Ensure DESCRIPTION exists for DISPLAY and EMAIL actions (RFC 5545: required for these actions)
+		actionValue := ""
+		if alarm.GetProperty(ics.ComponentPropertyAction) != nil {
+			actionValue = strings.ToUpper(alarm.GetProperty(ics.ComponentPropertyAction).Value)
+		}
+
+		if (actionValue == "DISPLAY" || actionValue == "EMAIL") &&
+			alarm.GetProperty(ics.ComponentPropertyDescription) == nil {
+			summary := event.GetProperty(ics.ComponentPropertySummary)
+			if summary != nil {
+				copySummaryToAlarm(alarm, ics.ComponentPropertyDescription, summary)
+			} else {
+				alarm.SetProperty(ics.ComponentPropertyDescription, "Event Reminder")
+			}
+			fixLog.AddFix(fmt.Sprintf("Added missing DESCRIPTION to %s alarm %d", actionValue, alarmCount))
+		}
+
+		// Ensure SUMMARY exists for EMAIL actions (RFC 5545: required for EMAIL)
+		if actionValue == "EMAIL" && alarm.GetProperty(ics.ComponentPropertySummary) == nil {
+			summary := event.GetProperty(ics.ComponentPropertySummary)
+			if summary != nil {
+				copySummaryToAlarm(alarm, ics.ComponentPropertySummary, summary)
+			} else {
+				alarm.SetProperty(ics.ComponentPropertySummary, "Event Reminder")
+			}
+			fixLog.AddFix(fmt.Sprintf("Added missing SUMMARY to EMAIL alarm %d", alarmCount))
+		}
+	}
+}
+
+// fixTriggerRelated checks the RELATED parameter of an alarm TRIGGER against the event.
+// RFC 5545: RELATED=END requires DTEND or DURATION; without them the trigger falls back to the
+// default RELATED=START. Values other than START and END are dropped as well.
+func fixTriggerRelated(alarm *ics.VAlarm, event *ics.VEvent, alarmCount int, fixLog *FixLog) {
+	trigger := alarm.GetProperty(ics.ComponentPropertyTrigger)
+	related := strings.ToUpper(firstParameter(*trigger, ics.ParameterRelated))
+	switch related {
+	case "", "START":
+		return
+	case "END":
+		if event.GetProperty(ics.ComponentPropertyDtEnd) != nil || event.GetProperty(ics.ComponentPropertyDuration) != nil {
+			return
+		}
+		fixLog.AddFix(fmt.Sprintf("Changed TRIGGER RELATED=END to START in alarm %d, the event has no DTEND or DURATION", alarmCount))
+	default:
+		fixLog.AddFix(fmt.Sprintf("Removed invalid TRIGGER RELATED value '%s' from alarm %d", related, alarmCount))
+	}
+	delete(trigger.ICalParameters, string(ics.ParameterRelated))
+}
+
+// fixFloatingTrigger resolves an absolute TRIGGER without a zone, which RFC 5545 requires to be UTC
+// and clients read differently. If DTSTART has a zone, the trigger is read as a time in that zone
+// and written in UTC. If DTSTART is floating or a date, the trigger becomes relative to the start,
+// except for recurring events, where it would then fire for every occurrence. Relative triggers
+// and absolute ones in UTC or with a TZID are left alone.
+func fixFloatingTrigger(alarm *ics.VAlarm, event *ics.VEvent, alarmCount int, fixLog *FixLog) {
+	trigger := alarm.GetProperty(ics.ComponentPropertyTrigger)
+	dtstart := event.GetProperty(ics.ComponentPropertyDtStart)
+	if dtstart == nil || !strings.EqualFold(firstParameter(*trigger, ics.ParameterValue), "DATE-TIME") ||
+		strings.HasSuffix(strings.ToUpper(strings.TrimSpace(trigger.Value)), "Z") || firstParameter(*trigger, ics.ParameterTzid) != "" {
+		return
+	}
+	start, err := parseEventTime(dtstart, time.UTC)
+	if err != nil {
+		return
+	}
+
+	if !isDateValue(dtstart) && !isFloatingDateTime(*dtstart) {
+		at, err := parseEventDate(strings.TrimSpace(trigger.Value), start.Location())
+		if err != nil {
+			return
+		}
+		trigger.Value = at.UTC().Format("20060102T150405Z")
+		if start.Location() == time.UTC {
+			fixLog.AddFix(fmt.Sprintf("Marked floating TRIGGER of alarm %d as UTC like DTSTART", alarmCount))
+		} else {
+			fixLog.AddFix(fmt.Sprintf("Converted floating TRIGGER of alarm %d from %s time to UTC", alarmCount, start.Location()))
+		}
+		return
+	}
+
+	if event.GetProperty(ics.ComponentPropertyRrule) != nil || event.GetProperty(ics.ComponentPropertyRdate) != nil {
+		return
+	}
+	at, err := parseEventDate(strings.TrimSpace(trigger.Value), time.UTC)
+	if err != nil {
+		return
+	}
+	relative := formatDuration(at.Sub(start))
+	if at.Before(start) {
+		relative = "-" + formatDuration(start.Sub(at))
+	}
+	trigger.Value = relative
+	delete(trigger.ICalParameters, string(ics.ParameterValue))
+	fixLog.AddFix(fmt.Sprintf("Converted floating TRIGGER of alarm %d to %s relative to DTSTART", alarmCount, relative))
+}
+
+// Defaults added by fixAlarmRepeat when only one of REPEAT and DURATION is given
+const (
+	defaultAlarmRepeat   = "1"
+	defaultAlarmDuration = "PT5M"
+)
+
+// fixAlarmRepeat completes a lone REPEAT or DURATION (RFC 5545: both or neither must occur in an alarm).
+// A usable value gets the default partner; a lone REPEAT:0, a negative or non-numeric REPEAT, or a
+// lone DURATION that is not a positive duration is removed. Complete pairs are left as they are.
+func fixAlarmRepeat(alarm *ics.VAlarm, alarmCount int, fixLog *FixLog) {
+	repeatProperty := ics.ComponentProperty(ics.PropertyRepeat)
+	repeat := alarm.GetProperty(repeatProperty)
+	duration := alarm.GetProperty(ics.ComponentPropertyDuration)
+
+	switch {
+	case repeat != nil && duration == nil:
+		if count, err := strconv.Atoi(strings.TrimSpace(repeat.Value)); err == nil && count > 0 {
+			alarm.SetProperty(ics.ComponentPropertyDuration, defaultAlarmDuration)
+			fixLog.AddFix(fmt.Sprintf("Added missing DURATION (%s) to repeating alarm %d", defaultAlarmDuration, alarmCount))
+		} else {
+			alarm.RemoveProperty(repeatProperty)
+			fixLog.AddFix(fmt.Sprintf("Removed REPEAT '%s' without DURATION from alarm %d", repeat.Value, alarmCount))
+		}
+	case duration != nil && repeat == nil:
+		if interval, err := ParseDuration(strings.TrimSpace(duration.Value)); err == nil && interval > 0 {
+			alarm.SetProperty(repeatProperty, defaultAlarmRepeat)
+			fixLog.AddFix(fmt.Sprintf("Added missing REPEAT (%s) to alarm %d with DURATION", defaultAlarmRepeat, alarmCount))
+		} else {
+			alarm.RemoveProperty(ics.ComponentPropertyDuration)
+			fixLog.AddFix(fmt.Sprintf("Removed DURATION '%s' without REPEAT from alarm %d", duration.Value, alarmCount))
+		}
+	}
+}
+
+// copySummaryToAlarm sets a text property of the alarm to the event SUMMARY.
+// The LANGUAGE parameter is copied along so clients still know which language the text is in.
+func copySummaryToAlarm(alarm *ics.VAlarm, property ics.ComponentProperty, summary *ics.IANAProperty) {
+	alarm.SetProperty(property, summary.Value)
+	if language := firstParameter(*summary, ics.ParameterLanguage); language != "" {
+		setParameter(alarm.GetProperty(property), ics.ParameterLanguage, language)
+	}
+}
+
+func fixTodo(todo *ics.VTodo) *FixLog {
+	fixLog := &FixLog{}
+
+	// Ensure UID exists
+	if todo.GetProperty(ics.ComponentPropertyUniqueId) == nil {
+		uid := generateUID()
+		todo.SetProperty(ics.ComponentPropertyUniqueId, uid)
+		fixLog.AddFix("Generated missing UID for TODO")
+	}
+
+	// Ensure DTSTAMP exists
+	if todo.GetProperty(ics.ComponentPropertyDtstamp) == nil {
+		now := nowFunc().UTC().Format("20060102T150405Z")
+		todo.SetProperty(ics.ComponentPropertyDtstamp, now)
+		fixLog.AddFix("Added missing DTSTAMP to TODO")
+	}
+
+	// Ensure SUMMARY exists
+	if todo.GetProperty(ics.ComponentPropertySummary) == nil {
+		todo.SetProperty(ics.ComponentPropertySummary, "Task")
+		fixLog.AddFix("Added default SUMMARY to TODO")
+	}
+
+	// Fix date-time properties
+	fixTodoDateTimes(todo, fixLog)
+
+	// Validate and fix STATUS property (RFC 5545: "NEEDS-ACTION" / "COMPLETED" / "IN-PROCESS" / "CANCELLED")
+	if status := todo.GetProperty(ics.ComponentPropertyStatus); status != nil {
+		if status.Value == "" {
+			status.Value = "NEEDS-ACTION"
+			fixLog.AddFix("Set empty TODO STATUS to NEEDS-ACTION")
+		} else if !isValidStatusValue(ics.ComponentVTodo, status.Value) {
+			fixLog.AddFix(fmt.Sprintf("Invalid TODO STATUS value '%s', changed to NEEDS-ACTION", status.Value))
+			status.Value = "NEEDS-ACTION"
+		}
+	}
+
+	// Validate PRIORITY
+	fixPriority(&todo.ComponentBase, fixLog)
+
+	// Fix completion properties
+	fixTodoCompletion(todo, fixLog)
+
+	return fixLog
+}
+
+func fixJournal(journal *ics.VJournal) *FixLog {
+	fixLog := &FixLog{}
+
+	// Ensure UID exists
+	if journal.GetProperty(ics.ComponentPropertyUniqueId) == nil {
+		journal.SetProperty(ics.ComponentPropertyUniqueId, generateUID())
+		fixLog.AddFix("Generated missing UID for JOURNAL")
+	}
+
+	// Ensure DTSTAMP exists
+	if journal.GetProperty(ics.ComponentPropertyDtstamp) == nil {
+		now := nowFunc().UTC().Format("20060102T150405Z")
+		journal.SetProperty(ics.ComponentPropertyDtstamp, now)
+		fixLog.AddFix("Added missing DTSTAMP to JOURNAL")
+	}
+
+	// Validate and fix STATUS property (RFC 5545: "DRAFT" / "FINAL" / "CANCELLED")
+	if status := journal.GetProperty(ics.ComponentPropertyStatus); status != nil {
+		if status.Value == "" {
+			status.Value = "FINAL"
+			fixLog.AddFix("Set empty JOURNAL STATUS to FINAL")
+		} else if !isValidStatusValue(ics.ComponentVJournal, status.Value) {
+			fixLog.AddFix(fmt.Sprintf("Invalid JOURNAL STATUS value '%s', changed to FINAL", status.Value))
+			status.Value = "FINAL"
+		}
+	}
+
+	return fixLog
+}
+
+func fixTodoCompletion(todo *ics.VTodo, fixLog *FixLog) {
+	// Validate PERCENT-COMPLETE (RFC 5545: integer between 0 and 100)
+	if percent := todo.GetProperty(ics.ComponentPropertyPercentComplete); percent != nil {
+		value, err := strconv.Atoi(strings.TrimSpace(percent.Value))
+		switch {
+		case err != nil:
+			fixLog.AddFix(fmt.Sprintf("Removed non-numeric PERCENT-COMPLETE '%s'", percent.Value))
+			todo.RemoveProperty(ics.ComponentPropertyPercentComplete)
+		case value < 0:
+			fixLog.AddFix(fmt.Sprintf("Clamped PERCENT-COMPLETE %d to 0", value))
+			percent.Value = "0"
+		case value > 100:
+			fixLog.AddFix(fmt.Sprintf("Clamped PERCENT-COMPLETE %d to 100", value))
+			percent.Value = "100"
+		}
+	}
+
+	// Completed TODOs are 100% done and have a completion time
+	status := todo.GetProperty(ics.ComponentPropertyStatus)
+	if status == nil || !strings.EqualFold(status.Value, "COMPLETED") {
+		return
+	}
+	if todo.GetProperty(ics.ComponentPropertyPercentComplete) == nil {
+		todo.SetProperty(ics.ComponentPropertyPercentComplete, "100")
+		fixLog.AddFix("Added PERCENT-COMPLETE 100 to completed TODO")
+	}
+	if todo.GetProperty(ics.ComponentPropertyCompleted) == nil {
+		now := nowFunc().UTC().Format("20060102T150405Z")
+		todo.SetProperty(ics.ComponentPropertyCompleted, now)
+		fixLog.AddFix("Added missing COMPLETED timestamp to completed TODO")
+	}
+}
+
+func fixTodoDateTimes(todo *ics.VTodo, fixLog *FixLog) {
+	dtstart := todo.GetProperty(ics.ComponentPropertyDtStart)
+	due := todo.GetProperty(ics.ComponentPropertyDue)
+
+	// Date values cannot carry a TZID
+	fixDateWithTzid(dtstart, fixLog)
+	fixDateWithTzid(due, fixLog)
+
+	// Fix DTSTART format (optional for TODOs)
+	if dtstart != nil {
+		originalValue := dtstart.Value
+		dtstart.Value = normalizeDateTimeProperty(*dtstart)
+		if originalValue != dtstart.Value {
+			fixLog.AddFix("Normalized TODO DTSTART format")
+		}
+	}
+
+	// Fix DUE format
+	if due != nil {
+		originalValue := due.Value
+		due.Value = normalizeDateTimeProperty(*due)
+		if originalValue != due.Value {
+			fixLog.AddFix("Normalized DUE format")
+		}
+	}
+
+	// Fix COMPLETED format (RFC 5545: COMPLETED MUST be a UTC date-time)
+	completed := todo.GetProperty(ics.ComponentPropertyCompleted)
+	if completed != nil {
+		originalValue := completed.Value
+		completed.Value = normalizeDateTime(completed.Value)
+		if originalValue != completed.Value {
+			fixLog.AddFix("Normalized COMPLETED format")
+		}
+	}
+
+	// A TODO cannot be completed before it starts, so such a COMPLETED is a data error. It is
+	// dropped rather than guessed; fixTodoCompletion adds a fresh one if the STATUS is COMPLETED.
+	if dtstart != nil && completed != nil {
+		startTime, startErr := parseEventTime(dtstart, time.UTC)
+		completedTime, completedErr := parseEventTime(completed, time.UTC)
+		if startErr == nil && completedErr == nil && completedTime.Before(startTime) {
+			fixLog.AddFix(fmt.Sprintf("Removed COMPLETED %s that predates DTSTART", completed.Value))
+			todo.RemoveProperty(ics.ComponentPropertyCompleted)
+		}
+	}
+
+	if dtstart == nil || due == nil {
+		return
+	}
+
+	// An all-day TODO is due at the earliest on the day after it starts
+	if isDateValue(dtstart) && isDateValue(due) {
+		startDate, startErr := parseDateTime(dtstart.Value)
+		dueDate, dueErr := parseDateTime(due.Value)
+		if startErr == nil && dueErr == nil && !dueDate.After(startDate) {
+			due.Value = startDate.AddDate(0, 0, 1).Format("20060102")
+			fixLog.AddFix("Fixed all-day DUE to be the day after DTSTART")
+		}
+		return
+	}
+
+	// Ensure DUE is after DTSTART (RFC 5545: DUE MUST be later than DTSTART), comparing instants
+	// in each property's zone like the DTEND check of events
+	startTime, startErr := parseEventTime(dtstart, time.UTC)
+	dueTime, dueErr := parseEventTime(due, time.UTC)
+	if startErr == nil && dueErr == nil && !dueTime.After(startTime) {
+		// Fix by adding 1 hour to start time
+		newDueTime := startTime.Add(time.Hour)
+		if firstParameter(*due, ics.ParameterTzid) != "" {
+			due.Value = newDueTime.In(dueTime.Location()).Format("20060102T150405")
+		} else {
+			due.Value = newDueTime.UTC().Format("20060102T150405Z")
+		}
+		fixLog.AddFix("Fixed DUE to be after DTSTART")
+	}
+}
+
+// randRead fills a byte slice with random data for generated UIDs. Tests replace it to exercise
+// the fallback.
+var randRead = rand.Read
+
+// uidCounter keeps fallback UIDs generated within the same nanosecond apart
+var uidCounter atomic.Uint64
+
+// generateUID returns a random 32-character hex UID in the ical-proxy.local domain. Should the
+// random source fail, it falls back to the current time and a counter, which are unique within
+// the process and keep the same length.
+func generateUID() string {
+	bytes := make([]byte, 16)
+	if n, err := randRead(bytes); err != nil || n != len(bytes) {
+		log.Printf("Failed to read random UID bytes (%d of %d read, error: %v), using a time-based UID", n, len(bytes), err)
+		return fmt.Sprintf("%016x%016x@ical-proxy.local", uint64(time.Now().UnixNano()), uidCounter.Add(1)) // #nosec G115 -- only the bits matter
+	}
+	return hex.EncodeToString(bytes) + "@ical-proxy.local"
+}
+
+// stableUID derives a UID from the SUMMARY, DTSTART (with its TZID), and DTEND of an event as they
+// appear in the feed, so the same source event gets the same UID on every refresh. seen counts the
+// hashes used so far: events that agree in all three are numbered in calendar order, since a UID
+// must still be unique within the calendar.
+func stableUID(event *ics.VEvent, seen map[string]int) string {
+	hash := sha256.New()
+	for _, name := range []ics.ComponentProperty{ics.ComponentPropertySummary, ics.ComponentPropertyDtStart, ics.ComponentPropertyDtEnd} {
+		if prop := event.GetProperty(name); prop != nil {
+			hash.Write([]byte(firstParameter(*prop, ics.ParameterTzid) + ":" + prop.Value))
+		}
+		hash.Write([]byte{0})
+	}
+	uid := hex.EncodeToString(hash.Sum(nil))[:32]
+
+	seen[uid]++
+	if n := seen[uid]; n > 1 {
+		uid += "-" + strconv.Itoa(n)
+	}
+	return uid + "@ical-proxy.local"
+}
+
+func normalizeDateTime(value string) string {
+	// Remove any invalid characters and normalize format
+	cleaned := strings.ReplaceAll(value, " ", "")
+	cleaned = strings.ReplaceAll(cleaned, "-", "")
+	cleaned = strings.ReplaceAll(cleaned, ":", "")
+	cleaned = stripFractionalSeconds(cleaned)
+
+	// If it looks like a date-time but doesn't end with Z, add it
+	if len(cleaned) == 15 && !strings.HasSuffix(cleaned, "Z") {
+		cleaned += "Z"
+	}
+
+	// If it's too short, pad with default time
+	if len(cleaned) == 8 {
+		cleaned += "T000000Z"
+	}
+
+	return cleaned
+}
+
+// normalizeDateTimeProperty normalizes the value of a date-time property like normalizeDateTime,
+// but keeps times with a TZID parameter local instead of marking them as UTC, and keeps VALUE=DATE
+// values as plain dates
+func normalizeDateTimeProperty(prop ics.IANAProperty) string {
+	normalized := normalizeDateTime(prop.Value)
+	if strings.EqualFold(firstParameter(prop, ics.ParameterValue), string(ics.ValueDataTypeDate)) {
+		if date := strings.TrimSuffix(normalized, "T000000Z"); len(date) == len("20060102") {
+			return date
+		}
+	}
+	if firstParameter(prop, ics.ParameterTzid) != "" && !strings.HasSuffix(prop.Value, "Z") {
+		return strings.TrimSuffix(normalized, "Z")
+	}
+	return normalized
+}
+
+// fixDateWithTzid turns a date-only value with a TZID parameter, such as
+// DTSTART;TZID=Europe/Berlin:20250728, into a plain VALUE=DATE value.
+// RFC 5545: TZID only applies to DATE-TIME values; fixTzidOnUtcTimes handles the UTC case.
+func fixDateWithTzid(prop *ics.IANAProperty, fixLog *FixLog) {
+	if prop == nil || firstParameter(*prop, ics.ParameterTzid) == "" || len(strings.TrimSpace(prop.Value)) != len("20060102") {
+		return
+	}
+	delete(prop.ICalParameters, string(ics.ParameterTzid))
+	setParameter(prop, ics.ParameterValue, string(ics.ValueDataTypeDate))
+	fixLog.AddFix(fmt.Sprintf("Replaced TZID on date-only %s with VALUE=DATE", prop.IANAToken))
+}
+
+// isFloatingDateTime reports whether a date-time property has neither a TZID nor a UTC designator.
+// Dates (all-day values) are not date-times and are never floating in this sense.
+func isFloatingDateTime(prop ics.IANAProperty) bool {
+	value := strings.TrimSpace(prop.Value)
+	return firstParameter(prop, ics.ParameterTzid) == "" &&
+		!strings.EqualFold(firstParameter(prop, ics.ParameterValue), "DATE") &&
+		strings.Contains(value, "T") && !strings.HasSuffix(value, "Z")
+}
+
+// stripFractionalSeconds removes the fraction of a second from date-times like 20250728T120000.000Z,
+// which some feeds write although RFC 5545 date-times have whole seconds. The time is truncated,
+// not rounded, and other values are returned unchanged.
+func stripFractionalSeconds(value string) string {
+	point := strings.LastIndexAny(value, ".,")
+	if point < 1 || value[point-1] < '0' || value[point-1] > '9' || !strings.Contains(value[:point], "T") {
+		return value
+	}
+	fraction := strings.TrimSuffix(value[point+1:], "Z")
+	if fraction == "" || strings.Trim(fraction, "0123456789") != "" {
+		return value
+	}
+	return value[:point] + value[point+1+len(fraction):]
+}
+
+func parseDateTime(value string) (time.Time, error) {
+	value = stripFractionalSeconds(value)
+
+	// Try different formats
+	formats := []string{
+		"20060102T150405Z",
+		"20060102T150405",
+		"20060102",
+	}
+
+	for _, format := range formats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+}
+
+// topLevelComponents may only appear directly inside VCALENDAR (RFC 5545 section 3.6)
+var topLevelComponents = map[string]bool{
+	"VEVENT":    true,
+	"VTODO":     true,
+	"VJOURNAL":  true,
+	"VFREEBUSY": true,
+	"VTIMEZONE": true,
+}
+
+// repairComponentNesting fixes mismatched BEGIN/END lines in raw iCal data before parsing, since the
+// parser rejects the whole feed otherwise. Missing END lines are inserted where the enclosing
+// component ends or a new top-level component begins, and END lines without a matching BEGIN are dropped.
+func repairComponentNesting(icalData []byte, fixLog *FixLog) []byte {
+	newline := "\n"
+	if bytes.Contains(icalData, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+
+	lines := strings.SplitAfter(string(icalData), "\n")
+	var out strings.Builder
+	out.Grow(len(icalData))
+	var stack []string
+	changed := false
+
+	// closeUntil writes END lines for open components until depth components remain
+	closeUntil := func(depth int) {
+		for len(stack) > depth {
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			out.WriteString("END:" + name + newline)
+			fixLog.AddFix(fmt.Sprintf("Inserted missing END:%s", name))
+			changed = true
+		}
+	}
+
+	for _, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		upper := strings.ToUpper(content)
+
+		switch {
+		case strings.HasPrefix(upper, "BEGIN:"):
+			name := strings.TrimSpace(upper[len("BEGIN:"):])
+			// A component cannot contain another component of its own type, and top-level
+			// components belong directly inside VCALENDAR
+			for depth, open := range stack {
+				if open == name || (topLevelComponents[name] && open != "VCALENDAR" && depth > 0 && stack[0] == "VCALENDAR") {
+					closeUntil(depth)
+					break
+				}
+			}
+			stack = append(stack, name)
+
+		case strings.HasPrefix(upper, "END:"):
+			name := strings.TrimSpace(upper[len("END:"):])
+			depth := -1
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == name {
+					depth = i
+					break
+				}
+			}
+			if depth == -1 {
+				fixLog.AddFix(fmt.Sprintf("Removed orphan END:%s", name))
+				changed = true
+				continue
+			}
+			closeUntil(depth + 1)
+			stack = stack[:depth]
+		}
+
+		out.WriteString(line)
+	}
+
+	if len(stack) > 0 {
+		if !strings.HasSuffix(out.String(), "\n") {
+			out.WriteString(newline)
+		}
+		closeUntil(0)
+	}
+
+	if !changed {
+		return icalData
+	}
+	return []byte(out.String())
+}
+
+// salvageCalendar recovers the parseable events of a feed that ics.ParseCalendar rejects.
+// Each VEVENT block is parsed on its own inside a minimal calendar, and the ones that parse are
+// added to the rest of the feed (calendar properties, time zones), or to an empty calendar
+// if that cannot be parsed either.
+func salvageCalendar(icalData []byte, fixLog *FixLog) (*ics.Calendar, error) {
+	lines := strings.SplitAfter(string(icalData), "\n")
+
+	var skeleton strings.Builder
+	var chunks []string
+	var chunk strings.Builder
+	inEvent := false
+	dropped := 0
+
+	for _, line := range lines {
+		switch strings.ToUpper(strings.TrimSpace(line)) {
+		case "BEGIN:VEVENT":
+			if inEvent {
+				// The previous event never ended
+				dropped++
+			}
+			inEvent = true
+			chunk.Reset()
+		case "END:VEVENT":
+			if inEvent {
+				chunk.WriteString(line)
+				chunks = append(chunks, chunk.String())
+				inEvent = false
+				continue
+			}
+		}
+
+		if inEvent {
+			chunk.WriteString(line)
+		} else {
+			skeleton.WriteString(line)
+		}
+	}
+	if inEvent {
+		dropped++
+	}
+
+	calendar, err := ics.ParseCalendar(strings.NewReader(skeleton.String()))
+	if err != nil {
+		calendar = ics.NewCalendar()
+		calendar.CalendarProperties = nil
+		calendar.SetVersion("2.0")
+		fixLog.AddFix("Dropped unparseable calendar properties")
+		salvageUnknownComponents(calendar, skeleton.String(), fixLog)
+	}
+
+	salvaged := 0
+	for _, chunk := range chunks {
+		wrapped := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.TrimRight(chunk, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+		parsed, err := ics.ParseCalendar(strings.NewReader(wrapped))
+		if err != nil || len(parsed.Events()) != 1 {
+			dropped++
+			continue
+		}
+		calendar.AddVEvent(parsed.Events()[0])
+		salvaged++
+	}
+
+	if salvaged == 0 {
+		return nil, fmt.Errorf("no events could be salvaged")
+	}
+
+	fixLog.AddFix(fmt.Sprintf("Salvaged %d events, dropped %d unparseable events", salvaged, dropped))
+	if dropped > 0 {
+		fixLog.AddWarning(fmt.Sprintf("Dropped %d events that could not be parsed", dropped))
+	}
+	return calendar, nil
+}
+
+func applyPostSerializationFixes(icalData string, fixLog *FixLog) string {
+	// Fix TZID parameters on UTC times
+	// RFC 5545: TZID parameter MUST NOT be applied to DATE-TIME properties whose time values are specified in UTC
+	fixed := fixTzidOnUtcTimes(icalData)
+	if fixed != icalData {
+		fixLog.AddFix("Removed TZID parameters from UTC times")
+	}
+
+	// Restore list separators in CATEGORIES
+	// RFC 5545: CATEGORIES is a comma-separated list, but the serializer escapes every comma as text
+	restored := fixCategoriesSeparators(fixed)
+	if restored != fixed {
+		fixLog.AddFix("Restored comma separators in CATEGORIES")
+	}
+	return restored
+}
+
+func fixTzidOnUtcTimes(icalData string) string {
+	// Fix TZID parameters on UTC times more robustly
+	// RFC 5545: TZID parameter MUST NOT be applied to DATE-TIME properties whose time values are specified in UTC
+	// Works on unfolded content lines so folded or quoted parameter values are never split
+	return rewriteContentLines(icalData, func(line string) string {
+		parsed, ok := parseContentLine(line)
+		if !ok || (parsed.name != "DTSTART" && parsed.name != "DTEND") || !strings.HasSuffix(parsed.value, "Z") {
+			return line
+		}
+
+		if _, hasTzid := parsed.param(string(ics.ParameterTzid)); !hasTzid {
+			return line
+		}
+
+		// Reconstruct line without TZID parameter, keeping the other parameters as they were
+		parsed.removeParam(string(ics.ParameterTzid))
+		return parsed.String()
+	})
+}
+
+func fixCategoriesSeparators(icalData string) string {
+	// Commas that were escaped in the source feed are kept escaped (see protectCategoryCommas)
+	return rewriteContentLines(icalData, func(line string) string {
+		parsed, ok := parseContentLine(line)
+		if !ok || parsed.name != "CATEGORIES" {
+			return line
+		}
+		value := strings.ReplaceAll(parsed.value, "\\,", ",")
+		parsed.value = strings.ReplaceAll(value, escapedCategoryComma, "\\,")
+		return parsed.String()
+	})
+}
+
+// protectCategoryCommas replaces escaped commas in CATEGORIES values with escapedCategoryComma before
+// parsing. The parser unescapes "\," to a plain comma, which would split the category in two.
+func protectCategoryCommas(icalData []byte) []byte {
+	if !bytes.Contains(icalData, []byte("\\,")) {
+		return icalData
+	}
+
+	lines := strings.SplitAfter(string(icalData), "\n")
+	var out strings.Builder
+	out.Grow(len(icalData))
+	inCategories := false
+
+	for _, line := range lines {
+		// Folded continuation lines belong to the property of the previous line
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			upper := strings.ToUpper(line)
+			inCategories = strings.HasPrefix(upper, "CATEGORIES:") || strings.HasPrefix(upper, "CATEGORIES;")
+		}
+		if !inCategories {
+			out.WriteString(line)
+			continue
+		}
+
+		for i := 0; i < len(line); i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				if line[i+1] == ',' {
+					out.WriteString(escapedCategoryComma)
+				} else {
+					out.WriteString(line[i : i+2])
+				}
+				i++
+				continue
+			}
+			out.WriteByte(line[i])
+		}
+	}
+
+	return []byte(out.String())
+}
@@ -0,0 +1,77 @@
+package icalfix
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// fuzzSeedCalendars are small calendars exercising the raw-string repairs: folding, quoted
+// parameters, TZID on UTC times, escaped category commas, and broken component nesting
+var fuzzSeedCalendars = []string{
+	"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\nBEGIN:VEVENT\r\nUID:1@example.com\r\nDTSTAMP:20250101T000000Z\r\nDTSTART;TZID=Europe/Berlin:20250728T120000Z\r\nSUMMARY:Test\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	"BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Folded\n  continuation line\nDTSTART:20250728\nCATEGORIES:A\\,B,C\nEND:VEVENT\nEND:VCALENDAR\n",
+	"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nATTENDEE;CN=\"Doe; Jane: PhD\":jane@example.com\r\nDTSTART:2025-07-28 12:00:00\r\nBEGIN:VALARM\r\nACTION:EMAIL\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	"BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nDUE;TZID=Europe/Berlin:20250728\r\nSTATUS:DONE\r\nPRIORITY:12\r\nEND:VTODO\r\nEND:VCALENDAR\r\n",
+	"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20250728T120000Z\r\nRDATE:20250801T120000Z,20250901T120000Z\r\nEXDATE:20250801T120000Z\r\nGEO:48,85;2,35\r\nEND:VEVENT\r\n",
+}
+
+// addFixtureSeeds adds the calendar files used by the tests to the fuzz corpus
+func addFixtureSeeds(f *testing.F) {
+	for _, seed := range fuzzSeedCalendars {
+		f.Add([]byte(seed))
+	}
+	paths, err := filepath.Glob(filepath.Join("..", "..", "server", "testdata", "*.ics"))
+	if err != nil {
+		f.Fatalf("Failed to list fixtures: %v", err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path) // #nosec G304 -- fixed test fixture directory
+		if err != nil {
+			f.Fatalf("Failed to read fixture %s: %v", path, err)
+		}
+		f.Add(data)
+	}
+}
+
+// FuzzProcessICalData feeds arbitrary bytes through the full pipeline. It must never panic,
+// and any calendar it returns must parse again.
+func FuzzProcessICalData(f *testing.F) {
+	addFixtureSeeds(f)
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, opts := range []*ProcessOptions{{}, {Salvage: true, Minify: true, Categories: CategoriesSplit}} {
+			result, err := ProcessICalDataWithOptions(data, opts)
+			if err != nil {
+				continue
+			}
+			if _, err := ics.ParseCalendar(strings.NewReader(result)); err != nil {
+				t.Fatalf("Output does not parse (%v):\n%q", err, result)
+			}
+		}
+	})
+}
+
+// FuzzNormalizeDateTime checks that date-time normalization never panics and is idempotent
+func FuzzNormalizeDateTime(f *testing.F) {
+	for _, seed := range []string{"20250728T120000Z", "20250728T120000", "20250728", "2025-07-28 12:00:00", "", "T", "Z"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		normalized := normalizeDateTime(value)
+		if strings.ContainsAny(normalized, " -:") {
+			t.Errorf("normalizeDateTime(%q) = %q still contains separators", value, normalized)
+		}
+		if again := normalizeDateTime(normalized); again != normalized {
+			t.Errorf("normalizeDateTime is not idempotent: %q -> %q -> %q", value, normalized, again)
+		}
+	})
+}
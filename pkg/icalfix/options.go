@@ -0,0 +1,218 @@
+package icalfix
+
+import (
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// ProcessOptions controls the optional filtering and transformation steps of ProcessICalDataWithOptions.
+// The zero value only applies the RFC 5545 fixes.
+type ProcessOptions struct {
+	// Passthrough returns the data as-is: it is neither parsed nor fixed, and all other options
+	// except CRLF are ignored. Only the check that the data is iCal at all is kept.
+	Passthrough bool
+	// CRLF normalizes the line endings of passed-through data to CRLF
+	CRLF bool
+
+	// Salvage recovers the parseable events when the feed as a whole cannot be parsed
+	Salvage bool
+	// SkipPreamble drops anything before the BEGIN:VCALENDAR line, like notices a server-side
+	// script printed first
+	SkipPreamble bool
+
+	// Charset is the charset the data was served with, e.g. from a Content-Type header. Latin-1 data
+	// is transcoded to UTF-8; without a charset, data that is not valid UTF-8 is assumed to be Latin-1.
+	Charset string
+
+	// ProdID is the PRODID added when the feed has none, instead of the server default
+	ProdID string
+	// ForceProdID replaces the PRODID of the calendar even if it is valid; empty keeps the existing one
+	ForceProdID string
+
+	// Name sets the display name of the calendar (NAME and X-WR-CALNAME); empty keeps the upstream's
+	Name string
+	// Color sets the RFC 7986 COLOR of the calendar, a CSS color name validated by FormatEventColor;
+	// empty keeps the upstream's
+	Color string
+
+	// RefreshInterval advertises how often clients should poll, as REFRESH-INTERVAL and
+	// X-PUBLISHED-TTL; zero leaves the upstream's values
+	RefreshInterval time.Duration
+
+	// FromDate and ToDate restrict events to a date range (both inclusive)
+	FromDate *time.Time
+	ToDate   *time.Time
+	// FilterLocation is the zone FromDate and ToDate are expressed in, and the zone floating
+	// event times are interpreted in when filtering; nil means UTC
+	FilterLocation *time.Location
+
+	// ApplyCalendarTZ interprets floating DTSTART and DTEND values in the zone given by X-WR-TIMEZONE
+	ApplyCalendarTZ bool
+
+	// DisableFixes lists event fixes to skip by their identifier in EventFixes, e.g. "dtend"
+	DisableFixes []string
+	// DefaultSummary is the SUMMARY added to events without a title or DESCRIPTION; empty means
+	// the package default DefaultSummary
+	DefaultSummary string
+	// StableUID derives missing event UIDs from SUMMARY, DTSTART, and DTEND, so they stay the same
+	// across refreshes, instead of generating random ones
+	StableUID bool
+
+	// Category keeps events with at least one of these categories; empty keeps all
+	Category []string
+	// CategoryAll keeps events with every one of these categories; empty keeps all
+	CategoryAll []string
+	// Location keeps events whose LOCATION matches; events without LOCATION are dropped. nil keeps all.
+	// LocationPattern builds the case-insensitive substring match the proxy uses.
+	Location *regexp.Regexp
+
+	// UID reduces the calendar to the event with this UID, including its overrides, and the
+	// VTIMEZONE components it references; ErrEventNotFound is returned if there is none. Empty keeps all.
+	UID string
+	// HideUIDs drops the events with any of these UIDs, including their overrides; matching is exact
+	HideUIDs []string
+
+	// Only is an allow-list of VEVENT properties to keep; empty means keep all
+	Only []string
+	// Strip lists VEVENT properties to remove
+	Strip []string
+
+	// Categories selects the CATEGORIES layout: "join" (one comma-separated property) or
+	// "split" (one property per category); empty keeps the single property the fixes produce
+	Categories string
+	// TitleCaseCategories capitalizes every word of each category, e.g. "team meeting" becomes "Team Meeting"
+	TitleCaseCategories bool
+
+	// UIDDomain replaces the domain after the @ of every event UID, or appends @<domain> to UIDs
+	// without one, keeping the local part; empty leaves UIDs as-is
+	UIDDomain string
+
+	// RewriteURLBase routes event URL properties through <base>?url=<original>; nil leaves them as-is
+	RewriteURLBase *url.URL
+
+	// ReverseGeocode looks up a place name for the GEO coordinates of events that have no LOCATION;
+	// nil leaves LOCATION alone. Events whose lookup fails keep an empty LOCATION.
+	ReverseGeocode func(lat, lon float64) (string, error)
+
+	// Anonymize replaces event details with a generic summary, keeping only timing and UID
+	Anonymize bool
+
+	// HideCancelled drops events whose STATUS is CANCELLED
+	HideCancelled bool
+
+	// Upcoming drops events that have already ended
+	Upcoming bool
+	// Offset skips this many events, ordered by start time, for paging through large feeds
+	Offset int
+	// Limit keeps at most this many events, ordered by start time; zero means no limit
+	Limit int
+
+	// Alarms selects how event alarms are adapted: "display" converts AUDIO alarms into DISPLAY
+	// alarms and "strip" removes all of them; empty keeps the alarms as fixed
+	Alarms string
+
+	// SetStatus replaces the STATUS of every event, e.g. CONFIRMED; FormatEventStatus validates it.
+	// Empty keeps the STATUS of the feed.
+	SetStatus string
+
+	// EventColor sets the COLOR of every event to this CSS color name (RFC 7986), e.g. teal;
+	// FormatEventColor validates it. Empty keeps the COLOR of the feed.
+	EventColor string
+
+	// MergeAdjacent merges events with the same SUMMARY whose intervals touch or overlap into one
+	// event spanning them all
+	MergeAdjacent bool
+
+	// PruneDangling removes RELATED-TO properties that point at UIDs no longer in the output
+	PruneDangling bool
+
+	// SplitMidnight replaces timed events that cross midnight with one event per day, using the
+	// day boundaries of FilterLocation
+	SplitMidnight bool
+
+	// AllDayReminder adds a display alarm this long before every all-day event; zero disables it
+	AllDayReminder time.Duration
+
+	// Minify produces the smallest valid calendar: missing optional properties are not added, and
+	// empty properties, CREATED, LAST-MODIFIED, SEQUENCE, TRANSP, CLASS, and X- extensions of
+	// events and TODOs are removed after fixing
+	Minify bool
+
+	// IncludeFreeBusy appends a VFREEBUSY component with the merged busy periods of the output
+	// events from FromDate through ToDate; it is only added when both are set
+	IncludeFreeBusy bool
+
+	// MaxOutputEvents keeps at most this many events in the output and notes the truncation in
+	// X-WR-CALDESC; zero means no limit. Unlike Limit it keeps the calendar order.
+	MaxOutputEvents int
+}
+
+// Option sets one field of ProcessOptions, so callers of Process only name the steps they need
+type Option func(*ProcessOptions)
+
+// NewProcessOptions returns ProcessOptions with the given options applied in order
+func NewProcessOptions(options ...Option) *ProcessOptions {
+	opts := &ProcessOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// WithDateRange restricts events to the range from..to (both inclusive); a nil bound is open
+func WithDateRange(from, to *time.Time) Option {
+	return func(opts *ProcessOptions) {
+		opts.FromDate = from
+		opts.ToDate = to
+	}
+}
+
+// WithTimezone sets the zone the date range and floating event times are interpreted in
+func WithTimezone(loc *time.Location) Option {
+	return func(opts *ProcessOptions) {
+		opts.FilterLocation = loc
+	}
+}
+
+// WithOnly keeps only the given VEVENT properties
+func WithOnly(names ...string) Option {
+	return func(opts *ProcessOptions) {
+		opts.Only = append(opts.Only, names...)
+	}
+}
+
+// WithStrip removes the given VEVENT properties
+func WithStrip(names ...string) Option {
+	return func(opts *ProcessOptions) {
+		opts.Strip = append(opts.Strip, names...)
+	}
+}
+
+// WithCategory keeps events with at least one of the given categories
+func WithCategory(categories ...string) Option {
+	return func(opts *ProcessOptions) {
+		opts.Category = append(opts.Category, categories...)
+	}
+}
+
+// WithHideUID drops the events with any of the given UIDs
+func WithHideUID(uids ...string) Option {
+	return func(opts *ProcessOptions) {
+		opts.HideUIDs = append(opts.HideUIDs, uids...)
+	}
+}
+
+// WithSalvage recovers the parseable events of feeds that cannot be parsed as a whole
+func WithSalvage() Option {
+	return func(opts *ProcessOptions) {
+		opts.Salvage = true
+	}
+}
+
+// WithHideCancelled drops events whose STATUS is CANCELLED
+func WithHideCancelled() Option {
+	return func(opts *ProcessOptions) {
+		opts.HideCancelled = true
+	}
+}
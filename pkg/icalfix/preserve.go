@@ -0,0 +1,70 @@
+package icalfix
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// salvageUnknownComponents keeps the components of an unparseable calendar skeleton that are not in
+// topLevelComponents, such as VAVAILABILITY, which would otherwise be lost with the rest of the
+// skeleton. Each block is parsed on its own inside a minimal calendar, so a block that is itself
+// broken is dropped rather than carried over. Lines starting with ';', which some generators write as
+// comments, are not part of iCalendar and are not preserved.
+func salvageUnknownComponents(calendar *ics.Calendar, skeleton string, fixLog *FixLog) {
+	blocks := unknownComponentBlocks([]byte(skeleton))
+	names := make([]string, 0, len(blocks))
+	for name := range blocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		preserved := 0
+		for _, block := range blocks[name] {
+			wrapped := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + block + "END:VCALENDAR\r\n"
+			parsed, err := ics.ParseCalendar(strings.NewReader(wrapped))
+			if err != nil || len(parsed.Components) != 1 {
+				continue
+			}
+			calendar.Components = append(calendar.Components, parsed.Components[0])
+			preserved++
+		}
+		if preserved > 0 {
+			fixLog.AddFix(fmt.Sprintf("Preserved %d of %d %s components from the unparseable calendar", preserved, len(blocks[name]), name))
+		}
+	}
+}
+
+// unknownComponentBlocks returns the raw blocks of the components directly inside VCALENDAR that
+// are not in topLevelComponents, by component name and with CRLF line endings
+func unknownComponentBlocks(icalData []byte) map[string][]string {
+	blocks := make(map[string][]string)
+	var block []string
+	name := ""
+	depth := 0
+	for _, line := range strings.Split(string(icalData), "\n") {
+		content := strings.TrimRight(line, "\r")
+		upper := strings.ToUpper(strings.TrimSpace(content))
+
+		if strings.HasPrefix(upper, "BEGIN:") {
+			depth++
+			if depth == 2 && !topLevelComponents[upper[len("BEGIN:"):]] {
+				name = upper[len("BEGIN:"):]
+			}
+		}
+		if name != "" {
+			block = append(block, content)
+		}
+		if strings.HasPrefix(upper, "END:") {
+			if depth == 2 && name != "" {
+				blocks[name] = append(blocks[name], strings.Join(block, "\r\n")+"\r\n")
+				block, name = nil, ""
+			}
+			depth--
+		}
+	}
+	return blocks
+}
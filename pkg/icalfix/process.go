@@ -0,0 +1,503 @@
+package icalfix
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// Process takes raw iCal data and returns a processed version with the given options applied
+func Process(icalData []byte, options ...Option) (string, error) {
+	return ProcessICalDataWithOptions(icalData, NewProcessOptions(options...))
+}
+
+// ProcessICalData takes raw iCal data and returns a processed version with optional date filtering.
+// It is kept for backward compatibility; new code should use Process.
+func ProcessICalData(icalData []byte, fromDate, toDate *time.Time) (string, error) {
+	return Process(icalData, WithDateRange(fromDate, toDate))
+}
+
+// ProcessICalDataWithOptions takes raw iCal data and returns a processed version with the given options applied
+func ProcessICalDataWithOptions(icalData []byte, opts *ProcessOptions) (string, error) {
+	fixedICal, _, err := ProcessICalDataWithReport(icalData, opts)
+	return fixedICal, err
+}
+
+// ProcessReport summarizes what processing changed; the server returns it for dry_run requests
+type ProcessReport struct {
+	Fixes     []FixEntry `json:"fixes"`
+	EventsIn  int        `json:"events_in"`
+	EventsOut int        `json:"events_out"`
+	TodosOut  int        `json:"todos_out,omitempty"`
+	BytesIn   int        `json:"bytes_in"`
+	BytesOut  int        `json:"bytes_out"`
+	// Truncated is set when MaxOutputEvents removed events
+	Truncated bool `json:"truncated"`
+	// Warnings lists non-fatal problems, such as unparseable dates, that processing passed over
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ProcessICalDataWithReport works like ProcessICalDataWithOptions and also reports the applied fixes
+func ProcessICalDataWithReport(icalData []byte, opts *ProcessOptions) (string, *ProcessReport, error) {
+	if len(icalData) == 0 {
+		return "", nil, fmt.Errorf("empty iCal data")
+	}
+
+	log.Printf("Starting iCal processing for %d bytes of data", len(icalData))
+
+	report := &ProcessReport{BytesIn: len(icalData)}
+	repairLog := &FixLog{}
+
+	// Drop the preamble before sniffing, since a long one pushes BEGIN:VCALENDAR past the sniffed
+	// prefix; passthrough returns the data untouched, preamble included
+	if opts.SkipPreamble && !opts.Passthrough {
+		icalData = dropPreamble(icalData, repairLog)
+	}
+
+	// Fail early with a clear error when the data is not iCal at all, e.g. an HTML error page
+	if !LooksLikeICal(icalData) {
+		return "", nil, NonCalendarContentError(icalData, "")
+	}
+
+	if opts.Passthrough {
+		return passthroughICalData(icalData, opts.CRLF, report), report, nil
+	}
+
+	// Decode the data so the repairs and the parser see UTF-8 text
+	icalData = toUTF8(icalData, opts.Charset, repairLog)
+
+	// Repair mismatched BEGIN/END blocks that would make parsing fail
+	icalData = repairComponentNesting(icalData, repairLog)
+	icalData = protectCategoryCommas(icalData)
+
+	calendar, err := ics.ParseCalendar(bytes.NewReader(icalData))
+	if err != nil && opts.Salvage {
+		log.Printf("Failed to parse iCal data (%v), salvaging individual events", err)
+		calendar, err = salvageCalendar(icalData, repairLog)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid iCal format: %w", err)
+	}
+	report.EventsIn = len(calendar.Events())
+
+	// Drop cancelled events while STATUS still reflects the source feed
+	if opts.HideCancelled {
+		removeCancelledEvents(calendar)
+	}
+	removeEventsByUID(calendar, opts.HideUIDs)
+
+	// Both category filters apply when given: an event must match any of Category and all of CategoryAll
+	filterEventsByAnyCategory(calendar, opts.Category)
+	filterEventsByAllCategories(calendar, opts.CategoryAll)
+	filterEventsByLocation(calendar, opts.Location)
+
+	// Apply date filtering if specified
+	if opts.FromDate != nil || opts.ToDate != nil {
+		filterEventsByDate(calendar, opts.FromDate, opts.ToDate, opts.FilterLocation)
+	}
+
+	// Keep only upcoming and/or the first N events; runs after the other filters
+	selectUpcomingEvents(calendar, opts.Upcoming, opts.Offset, opts.Limit, nowFunc(), opts.FilterLocation)
+
+	if opts.TitleCaseCategories {
+		titleCaseCategories(calendar)
+	}
+
+	// Use the requested PRODID instead of the default when the feed has none
+	if opts.ProdID != "" && calendarProdID(calendar) == "" {
+		calendar.SetProductId(opts.ProdID)
+		repairLog.AddFix(fmt.Sprintf("Added missing PRODID '%s'", opts.ProdID))
+	}
+
+	// Apply comprehensive fixes to ensure RFC 5545 compliance
+	fixLog := FixCalendar(calendar, FixOptions{
+		ApplyCalendarTZ:        opts.ApplyCalendarTZ,
+		SkipOptionalProperties: opts.Minify,
+		Disable:                opts.DisableFixes,
+		Summary:                opts.DefaultSummary,
+		StableUID:              opts.StableUID,
+	})
+	fixLog.Prepend(repairLog)
+
+	// Override STATUS after the fixes added their default, so the requested value wins
+	if opts.SetStatus != "" {
+		setEventStatus(calendar, opts.SetStatus)
+	}
+
+	// Select a single event after the fixes, so events whose UID was generated can be picked too
+	if opts.UID != "" {
+		if err := selectEventByUID(calendar, opts.UID); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Merge after the fixes, so that events have a DTEND to compare
+	if opts.MergeAdjacent {
+		mergeAdjacentEvents(calendar, opts.FilterLocation)
+	}
+
+	// Apply CATEGORIES layout normalization if requested; runs after the fixes merged them into one property
+	normalizeCategories(calendar, opts.Categories)
+
+	// Replace even a valid PRODID only when explicitly forced
+	if existing := calendarProdID(calendar); opts.ForceProdID != "" && existing != opts.ForceProdID {
+		calendar.SetProductId(opts.ForceProdID)
+		fixLog.AddFix(fmt.Sprintf("Replaced PRODID '%s' with '%s'", existing, opts.ForceProdID))
+	}
+
+	// Name and color the output calendar; without a name the upstream's X-WR-CALNAME is kept
+	if opts.Name != "" {
+		calendar.SetName(opts.Name)
+	}
+	if opts.Color != "" {
+		calendar.SetColor(opts.Color)
+	}
+	setRefreshInterval(calendar, opts.RefreshInterval)
+
+	// Geocode after the fixes repaired GEO values, and before property selection so that LOCATION
+	// can still be stripped; anonymized events lose both, so they are not looked up at all
+	if !opts.Anonymize {
+		fillLocationsFromGeo(calendar, opts.ReverseGeocode, fixLog)
+	}
+
+	// Apply property selection after fixing so required properties are always present
+	selectEventProperties(calendar, opts.Only, opts.Strip)
+	rewriteEventURLs(calendar, opts.RewriteURLBase)
+	rewriteUIDDomains(calendar, opts.UIDDomain)
+	if opts.Anonymize {
+		anonymizeEvents(calendar)
+	}
+
+	// Color events after property selection and anonymization, so neither removes the requested COLOR
+	if opts.EventColor != "" {
+		setEventColor(calendar, opts.EventColor)
+	}
+
+	// Split after the fixes so events have their DTEND; segments outside the date range are dropped again
+	if opts.SplitMidnight {
+		splitMidnightEvents(calendar, opts.FilterLocation)
+		if opts.FromDate != nil || opts.ToDate != nil {
+			filterEventsByDate(calendar, opts.FromDate, opts.ToDate, opts.FilterLocation)
+		}
+	}
+
+	// Convert or strip the upstream's alarms before our own reminders are added
+	convertAlarms(calendar, opts.Alarms)
+
+	// Add reminders last so they are neither anonymized away nor affected by property selection
+	addAllDayReminders(calendar, opts.AllDayReminder)
+
+	// Minify as a final pass over the fixed and transformed events
+	if opts.Minify {
+		minifyCalendar(calendar)
+	}
+
+	// Guard against oversized responses last so it applies to the final output
+	report.Truncated = truncateEvents(calendar, opts.MaxOutputEvents)
+
+	// Prune after truncation, so references to events that any step dropped are caught
+	if opts.PruneDangling {
+		pruneDanglingRelations(calendar)
+	}
+
+	// Summarize the busy time after truncation, so it covers exactly the events in the output
+	if opts.IncludeFreeBusy && opts.FromDate != nil && opts.ToDate != nil {
+		addFreeBusy(calendar, *opts.FromDate, opts.ToDate.AddDate(0, 0, 1), opts.FilterLocation)
+	}
+
+	// Serialize with proper CRLF line endings (RFC 5545 requirement)
+	fixedICal := calendar.Serialize(ics.WithNewLine("\r\n"))
+
+	// Apply post-serialization fixes for issues that can't be handled during object manipulation
+	fixedICal = applyPostSerializationFixes(fixedICal, fixLog)
+
+	// Log summary of fixes applied
+	log.Printf("iCal processing complete. %s", fixLog.GetSummary())
+
+	report.Fixes = append([]FixEntry{}, fixLog.Entries...)
+	report.Warnings = fixLog.Warnings
+	report.EventsOut = len(calendar.Events())
+	report.TodosOut = len(calendar.Todos())
+	report.BytesOut = len(fixedICal)
+
+	return fixedICal, report, nil
+}
+
+// passthroughICalData returns the data unchanged, or with its line endings normalized to CRLF
+// if crlf is set, and fills in the report without parsing the calendar
+func passthroughICalData(icalData []byte, crlf bool, report *ProcessReport) string {
+	output := string(icalData)
+	if crlf {
+		output = strings.ReplaceAll(strings.ReplaceAll(output, "\r\n", "\n"), "\n", "\r\n")
+	}
+	log.Printf("Passed %d bytes of iCal data through without fixes", len(icalData))
+
+	events, todos := 0, 0
+	for _, line := range strings.Split(output, "\n") {
+		switch strings.ToUpper(strings.TrimSpace(line)) {
+		case "BEGIN:VEVENT":
+			events++
+		case "BEGIN:VTODO":
+			todos++
+		}
+	}
+	report.Fixes = []FixEntry{}
+	report.EventsIn, report.EventsOut = events, events
+	report.TodosOut = todos
+	report.BytesOut = len(output)
+	return output
+}
+
+// filterEventsByDate removes events that do not overlap the specified date range.
+// Both boundaries are inclusive: an event is kept if it is still running at the beginning of
+// fromDate and starts no later than the end of toDate (23:59:59), so an event starting at
+// midnight of the following day is excluded. Floating and all-day event times are interpreted
+// in loc (UTC when nil), so all-day events are effectively compared by date only.
+func filterEventsByDate(calendar *ics.Calendar, fromDate, toDate *time.Time, loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	events := calendar.Events()
+	kept := make([]*ics.VEvent, 0, len(events))
+
+	var toEndOfDay time.Time
+	if toDate != nil {
+		toEndOfDay = toDate.AddDate(0, 0, 1).Add(-time.Second)
+	}
+
+	// outsideRange reports whether an occurrence does not overlap the date range
+	outsideRange := func(start, end time.Time) bool {
+		// Check if the occurrence is over before fromDate (end is exclusive unless it is instantaneous)
+		if fromDate != nil {
+			if end.Equal(start) && start.Before(*fromDate) {
+				return true
+			}
+			if !end.Equal(start) && !end.After(*fromDate) {
+				return true
+			}
+		}
+
+		// Check if the occurrence starts after toDate
+		return toDate != nil && start.After(toEndOfDay)
+	}
+
+	for _, event := range events {
+		shouldRemove := false
+
+		if eventStart, eventEnd, ok := eventInterval(event, loc); ok {
+			if hasExplicitOccurrences(event) {
+				shouldRemove = !filterExplicitOccurrences(event, eventStart, eventEnd, loc, outsideRange)
+			} else {
+				shouldRemove = outsideRange(eventStart, eventEnd)
+			}
+		}
+
+		if !shouldRemove {
+			kept = append(kept, event)
+		}
+	}
+
+	// Keep events by identity rather than UID, since overrides and split segments share their UID
+	replaceEvents(calendar, kept)
+
+	log.Printf("Filtered out %d events based on date range", len(events)-len(kept))
+}
+
+// eventInterval returns the start and end of an event for date filtering.
+// Events without a parseable DTEND end when they start. A DATE-valued DTEND on an event with a
+// timed DTSTART is treated as the end of that day, so such mixed events are not cut short.
+func eventInterval(event *ics.VEvent, loc *time.Location) (time.Time, time.Time, bool) {
+	startProp := event.GetProperty(ics.ComponentPropertyDtStart)
+	if startProp == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	eventStart, err := parseEventTime(startProp, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	eventEnd := eventStart
+	if endProp := event.GetProperty(ics.ComponentPropertyDtEnd); endProp != nil {
+		if parsedEnd, err := parseEventTime(endProp, loc); err == nil {
+			eventEnd = parsedEnd
+			if isDateValue(endProp) && !isDateValue(startProp) {
+				eventEnd = parsedEnd.AddDate(0, 0, 1).Add(-time.Second)
+			}
+		}
+	}
+
+	if eventEnd.Before(eventStart) {
+		eventEnd = eventStart
+	}
+	return eventStart, eventEnd, true
+}
+
+// hasExplicitOccurrences reports whether an event enumerates its occurrences with RDATE instead of an RRULE
+func hasExplicitOccurrences(event *ics.VEvent) bool {
+	return event.GetProperty(ics.ComponentPropertyRrule) == nil && event.GetProperty(ics.ComponentPropertyRdate) != nil
+}
+
+// filterExplicitOccurrences applies date filtering to the occurrences of an event with RDATE but no RRULE:
+// the DTSTART occurrence and each RDATE, minus those listed in EXDATE. RDATE values that are excluded or
+// outside the range are removed, and the event is kept (true) if any occurrence remains. Every occurrence
+// lasts as long as the event itself.
+func filterExplicitOccurrences(event *ics.VEvent, eventStart, eventEnd time.Time, loc *time.Location, outsideRange func(start, end time.Time) bool) bool {
+	duration := eventEnd.Sub(eventStart)
+
+	excluded := make(map[int64]bool)
+	for _, prop := range event.GetProperties(ics.ComponentPropertyExdate) {
+		for _, occurrence := range parseOccurrenceList(prop, loc) {
+			excluded[occurrence.Unix()] = true
+		}
+	}
+
+	kept := !excluded[eventStart.Unix()] && !outsideRange(eventStart, eventEnd)
+
+	properties := event.Properties[:0]
+	removed := 0
+	for _, prop := range event.Properties {
+		if prop.IANAToken != string(ics.ComponentPropertyRdate) {
+			properties = append(properties, prop)
+			continue
+		}
+
+		var values []string
+		for _, value := range strings.Split(prop.Value, ",") {
+			start, err := parseOccurrence(&prop, value, loc)
+			if err == nil && (excluded[start.Unix()] || outsideRange(start, start.Add(duration))) {
+				removed++
+				continue
+			}
+			// Values that cannot be parsed are passed through untouched
+			values = append(values, value)
+			kept = kept || err == nil
+		}
+
+		if len(values) > 0 {
+			prop.Value = strings.Join(values, ",")
+			properties = append(properties, prop)
+		}
+	}
+	event.Properties = properties
+
+	if removed > 0 {
+		log.Printf("Removed %d RDATE values outside the date range or excluded by EXDATE", removed)
+	}
+	return kept
+}
+
+// parseOccurrenceList parses the comma-separated values of an RDATE or EXDATE property
+func parseOccurrenceList(prop *ics.IANAProperty, loc *time.Location) []time.Time {
+	var occurrences []time.Time
+	for _, value := range strings.Split(prop.Value, ",") {
+		if occurrence, err := parseOccurrence(prop, value, loc); err == nil {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	return occurrences
+}
+
+// parseOccurrence parses one value of an RDATE or EXDATE property with the parameters of prop.
+// For PERIOD values (start/end or start/duration) only the start is used.
+func parseOccurrence(prop *ics.IANAProperty, value string, loc *time.Location) (time.Time, error) {
+	start, _, _ := strings.Cut(strings.TrimSpace(value), "/")
+	occurrence := ics.IANAProperty{BaseProperty: ics.BaseProperty{
+		IANAToken:      prop.IANAToken,
+		ICalParameters: prop.ICalParameters,
+		Value:          start,
+	}}
+	return parseEventTime(&occurrence, loc)
+}
+
+// parseEventTime parses a date-time property as an instant. UTC values are absolute, values with
+// a resolvable TZID are interpreted in that zone, and floating or DATE values in loc.
+func parseEventTime(prop *ics.IANAProperty, loc *time.Location) (time.Time, error) {
+	if tzids, ok := prop.ICalParameters[string(ics.ParameterTzid)]; ok && len(tzids) == 1 && !isDateValue(prop) {
+		if tzLoc, err := LoadLocation(tzids[0]); err == nil {
+			loc = tzLoc
+		} else {
+			log.Printf("Unknown TZID '%s' on %s, using %s", tzids[0], prop.IANAToken, loc)
+		}
+	}
+	return parseEventDate(prop.Value, loc)
+}
+
+// isDateValue reports whether a date-time property holds a DATE rather than a DATE-TIME value
+func isDateValue(prop *ics.IANAProperty) bool {
+	if values, ok := prop.ICalParameters[string(ics.ParameterValue)]; ok && len(values) == 1 {
+		return strings.EqualFold(values[0], string(ics.ValueDataTypeDate))
+	}
+	return !strings.Contains(prop.Value, "T")
+}
+
+// parseEventDate parses various iCal date formats, interpreting values without a trailing Z in loc
+func parseEventDate(dateStr string, loc *time.Location) (time.Time, error) {
+	dateStr = stripFractionalSeconds(dateStr)
+	if strings.HasSuffix(dateStr, "Z") {
+		loc = time.UTC
+	}
+
+	// Try different date formats used in iCal
+	formats := []string{
+		"20060102T150405Z",     // UTC format
+		"20060102T150405",      // Local format
+		"20060102",             // Date only
+		"2006-01-02T15:04:05Z", // RFC3339 UTC
+		"2006-01-02T15:04:05",  // RFC3339 local
+		"2006-01-02",           // Date only with dashes
+	}
+
+	for _, format := range formats {
+		if t, err := time.ParseInLocation(format, dateStr, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse date: %s", dateStr)
+}
+
+// FixICalData is kept for backward compatibility but now uses ProcessICalData
+func FixICalData(icalData []byte) (string, error) {
+	return ProcessICalData(icalData, nil, nil)
+}
+
+// LastModified returns the latest LAST-MODIFIED time of the components in raw iCal data, or the
+// zero time if there is none. It scans the content lines without parsing the calendar, so it is
+// cheap enough to run before deciding whether the data needs processing at all.
+func LastModified(icalData []byte) time.Time {
+	var latest time.Time
+	for _, line := range strings.Split(string(icalData), "\n") {
+		parsed, ok := parseContentLine(strings.TrimRight(line, "\r"))
+		if !ok || !strings.EqualFold(parsed.name, string(ics.ComponentPropertyLastModified)) {
+			continue
+		}
+		if modified, err := parseDateTime(strings.TrimSpace(parsed.value)); err == nil && modified.After(latest) {
+			latest = modified
+		}
+	}
+	return latest
+}
+
+// CalendarName returns the display name (X-WR-CALNAME) of serialized iCal data, or "" if it has
+// none. Like LastModified it scans the content lines instead of parsing the calendar.
+func CalendarName(icalData string) string {
+	unfolded := strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(icalData)
+	for _, line := range strings.Split(unfolded, "\n") {
+		parsed, ok := parseContentLine(strings.TrimRight(line, "\r"))
+		if !ok {
+			continue
+		}
+		// Calendar properties come before the first component
+		if strings.EqualFold(parsed.name, "BEGIN") && !strings.EqualFold(parsed.value, "VCALENDAR") {
+			break
+		}
+		if strings.EqualFold(parsed.name, string(ics.PropertyXWRCalName)) {
+			return ics.FromText(parsed.value)
+		}
+	}
+	return ""
+}
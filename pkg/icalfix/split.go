@@ -0,0 +1,117 @@
+package icalfix
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// uncategorizedFile is the name of the zip entry holding events without CATEGORIES
+const uncategorizedFile = "uncategorized.ics"
+
+// SplitCalendarByCategory partitions the events of a processed calendar by CATEGORIES and returns
+// one serialized calendar per file name. Each calendar keeps the calendar properties and
+// non-event components (such as VTIMEZONE) of the original and is named after its category.
+func SplitCalendarByCategory(icalData string) (map[string]string, error) {
+	calendar, err := ics.ParseCalendar(bytes.NewReader(protectCategoryCommas([]byte(icalData))))
+	if err != nil {
+		return nil, fmt.Errorf("invalid iCal format: %w", err)
+	}
+
+	type categoryCalendar struct {
+		name   string
+		events []*ics.VEvent
+	}
+
+	// Categories are matched case-insensitively; the first spelling seen names the calendar
+	byFile := make(map[string]*categoryCalendar)
+	for _, event := range calendar.Events() {
+		categories := eventCategories(event)
+		if len(categories) == 0 {
+			categories = []string{""}
+		}
+
+		seen := make(map[string]bool)
+		for _, category := range categories {
+			file := categoryFileName(category)
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+
+			if byFile[file] == nil {
+				byFile[file] = &categoryCalendar{name: category}
+			}
+			byFile[file].events = append(byFile[file].events, event)
+		}
+	}
+
+	files := make(map[string]string, len(byFile))
+	for file, entry := range byFile {
+		split := &ics.Calendar{CalendarProperties: append([]ics.CalendarProperty(nil), calendar.CalendarProperties...)}
+		for _, component := range calendar.Components {
+			if _, isEvent := component.(*ics.VEvent); !isEvent {
+				split.Components = append(split.Components, component)
+			}
+		}
+		for _, event := range entry.events {
+			split.Components = append(split.Components, event)
+		}
+		if entry.name != "" {
+			split.SetXWRCalName(entry.name)
+		}
+
+		serialized := split.Serialize(ics.WithNewLine("\r\n"))
+		files[file] = applyPostSerializationFixes(serialized, &FixLog{})
+	}
+
+	return files, nil
+}
+
+// EventFragments returns the VEVENT blocks of a processed calendar, with their alarms, without the
+// VCALENDAR wrapper, so that a downstream tool can splice them into another calendar. The result
+// is not a valid calendar on its own: VERSION, PRODID, and the VTIMEZONE components the events
+// may reference are left out.
+func EventFragments(icalData string) (string, error) {
+	calendar, err := ics.ParseCalendar(bytes.NewReader(protectCategoryCommas([]byte(icalData))))
+	if err != nil {
+		return "", fmt.Errorf("invalid iCal format: %w", err)
+	}
+
+	config := &ics.SerializationConfiguration{MaxLength: 75, PropertyMaxLength: 75, NewLine: "\r\n"}
+	var fragments strings.Builder
+	for _, event := range calendar.Events() {
+		if err := event.SerializeTo(&fragments, config); err != nil {
+			return "", fmt.Errorf("failed to serialize event: %w", err)
+		}
+	}
+	return applyPostSerializationFixes(fragments.String(), &FixLog{}), nil
+}
+
+// categoryFileName turns a category into a safe, lower-case zip entry name
+func categoryFileName(category string) string {
+	name := FileName(category)
+	if name == "" {
+		return uncategorizedFile
+	}
+	return name + ".ics"
+}
+
+// FileName turns a calendar or category name into a lower-case file name without extension,
+// e.g. "Private Stuff" into "private-stuff", or "" if the name has no letters or digits
+func FileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			return unicode.ToLower(r)
+		case r == '-' || r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, name)
+	return strings.Trim(name, "-")
+}
@@ -0,0 +1,1230 @@
+package icalfix
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// requiredEventProperties are never removed by property selection, since an event without them is invalid
+var requiredEventProperties = []ics.ComponentProperty{
+	ics.ComponentPropertyUniqueId,
+	ics.ComponentPropertyDtstamp,
+	ics.ComponentPropertyDtStart,
+}
+
+// anonymizedEventProperties are the timing properties kept on anonymized events
+var anonymizedEventProperties = map[string]bool{
+	string(ics.ComponentPropertyUniqueId):     true,
+	string(ics.ComponentPropertyDtstamp):      true,
+	string(ics.ComponentPropertyDtStart):      true,
+	string(ics.ComponentPropertyDtEnd):        true,
+	string(ics.ComponentPropertyDuration):     true,
+	string(ics.ComponentPropertyRrule):        true,
+	string(ics.ComponentPropertyRdate):        true,
+	string(ics.ComponentPropertyExdate):       true,
+	string(ics.ComponentPropertyRecurrenceId): true,
+	string(ics.ComponentPropertyTransp):       true,
+}
+
+// anonymizedSummary replaces the SUMMARY of anonymized events
+const anonymizedSummary = "Busy"
+
+// selectEventProperties applies the 'only' allow-list and the 'strip' deny-list to every event.
+// It runs after fixing so that required properties are guaranteed to exist and are always kept.
+func selectEventProperties(calendar *ics.Calendar, only, strip []string) {
+	if len(only) == 0 && len(strip) == 0 {
+		return
+	}
+
+	keep := make(map[string]bool)
+	for _, name := range only {
+		keep[name] = true
+	}
+	drop := make(map[string]bool)
+	for _, name := range strip {
+		drop[name] = true
+	}
+	for _, required := range requiredEventProperties {
+		keep[string(required)] = true
+		delete(drop, string(required))
+	}
+
+	removed := 0
+	for _, event := range calendar.Events() {
+		properties := event.Properties[:0]
+		for _, prop := range event.Properties {
+			if (len(only) > 0 && !keep[prop.IANAToken]) || drop[prop.IANAToken] {
+				removed++
+				continue
+			}
+			properties = append(properties, prop)
+		}
+		event.Properties = properties
+	}
+
+	log.Printf("Removed %d event properties based on property selection", removed)
+}
+
+// Supported CATEGORIES layouts for normalizeCategories
+const (
+	CategoriesJoin  = "join"
+	CategoriesSplit = "split"
+)
+
+// normalizeCategories rewrites the CATEGORIES of every event either into a single comma-joined
+// property or into one property per category, since clients disagree on which layout they read
+func normalizeCategories(calendar *ics.Calendar, mode string) {
+	if mode == "" {
+		return
+	}
+
+	changed := 0
+	for _, event := range calendar.Events() {
+		props := event.GetProperties(ics.ComponentPropertyCategories)
+		if len(props) == 0 {
+			continue
+		}
+
+		categories := eventCategories(event)
+
+		if mode == CategoriesJoin && len(props) == 1 {
+			continue
+		}
+		if mode == CategoriesSplit && len(props) == len(categories) {
+			continue
+		}
+
+		event.RemoveProperty(ics.ComponentPropertyCategories)
+		if mode == CategoriesJoin {
+			event.AddProperty(ics.ComponentPropertyCategories, joinCategories(categories))
+		} else {
+			for _, category := range categories {
+				event.AddProperty(ics.ComponentPropertyCategories, categoryValue(category))
+			}
+		}
+		changed++
+	}
+
+	log.Printf("Normalized CATEGORIES (%s) on %d events", mode, changed)
+}
+
+// calendarProdID returns the PRODID of a calendar, or "" if it has none
+func calendarProdID(calendar *ics.Calendar) string {
+	for _, prop := range calendar.CalendarProperties {
+		if prop.IANAToken == string(ics.PropertyProductId) {
+			return prop.Value
+		}
+	}
+	return ""
+}
+
+// escapedCategoryComma stands in for an escaped comma ("\,") inside a category from parsing until
+// serialization, so it is not mistaken for a list separator. U+FDD0 is a noncharacter reserved for
+// internal use, so it does not occur in real feeds.
+const escapedCategoryComma = "\uFDD0"
+
+// eventCategories returns the trimmed categories of an event across all its CATEGORIES properties
+func eventCategories(event *ics.VEvent) []string {
+	var categories []string
+	for _, prop := range event.GetProperties(ics.ComponentPropertyCategories) {
+		for _, category := range strings.Split(prop.Value, ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				categories = append(categories, strings.ReplaceAll(category, escapedCategoryComma, ","))
+			}
+		}
+	}
+	return categories
+}
+
+// categoryValue encodes a category as returned by eventCategories for use in a CATEGORIES value
+func categoryValue(category string) string {
+	return strings.ReplaceAll(category, ",", escapedCategoryComma)
+}
+
+// joinCategories encodes categories as a single comma-separated CATEGORIES value
+func joinCategories(categories []string) string {
+	values := make([]string, len(categories))
+	for i, category := range categories {
+		values[i] = categoryValue(category)
+	}
+	return strings.Join(values, ",")
+}
+
+// titleCaseCategories capitalizes the first letter of every word in each category and lower-cases the rest
+func titleCaseCategories(calendar *ics.Calendar) {
+	changed := 0
+	for _, event := range calendar.Events() {
+		for i := range event.Properties {
+			prop := &event.Properties[i]
+			if prop.IANAToken != string(ics.ComponentPropertyCategories) {
+				continue
+			}
+			if titled := titleCase(prop.Value); titled != prop.Value {
+				prop.Value = titled
+				changed++
+			}
+		}
+	}
+
+	log.Printf("Title-cased %d CATEGORIES properties", changed)
+}
+
+// titleCase upper-cases the first letter of every word and lower-cases all other letters
+func titleCase(value string) string {
+	startOfWord := true
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ',' {
+			startOfWord = true
+			return r
+		}
+		if startOfWord {
+			startOfWord = false
+			return unicode.ToUpper(r)
+		}
+		return unicode.ToLower(r)
+	}, value)
+}
+
+// rewriteEventURLs points the URL property of every event at base, passing the original URL as the
+// 'url' query parameter, so links to resources behind authentication go through a companion proxy
+func rewriteEventURLs(calendar *ics.Calendar, base *url.URL) {
+	if base == nil {
+		return
+	}
+
+	for _, event := range calendar.Events() {
+		for i := range event.Properties {
+			prop := &event.Properties[i]
+			if prop.IANAToken != string(ics.ComponentPropertyUrl) || prop.Value == "" {
+				continue
+			}
+
+			rewritten := *base
+			query := rewritten.Query()
+			query.Set("url", prop.Value)
+			rewritten.RawQuery = query.Encode()
+
+			log.Printf("Rewrote event URL %s to %s", prop.Value, rewritten.String())
+			prop.Value = rewritten.String()
+		}
+	}
+}
+
+// rewriteUIDDomains moves the UID of every event to domain: the part after the last @ is replaced,
+// and UIDs without an @ get @<domain> appended. RELATED-TO references are rewritten the same way,
+// so they keep pointing at the same events.
+func rewriteUIDDomains(calendar *ics.Calendar, domain string) {
+	if domain == "" {
+		return
+	}
+
+	rewritten := 0
+	for _, event := range calendar.Events() {
+		for i := range event.Properties {
+			prop := &event.Properties[i]
+			if prop.IANAToken != string(ics.ComponentPropertyUniqueId) && prop.IANAToken != string(ics.ComponentPropertyRelatedTo) {
+				continue
+			}
+			if uid := withUIDDomain(prop.Value, domain); uid != prop.Value {
+				prop.Value = uid
+				rewritten++
+			}
+		}
+	}
+	log.Printf("Moved %d UIDs to domain %s", rewritten, domain)
+}
+
+// withUIDDomain returns uid with its domain replaced by domain, keeping the local part
+func withUIDDomain(uid, domain string) string {
+	uid = strings.TrimSpace(uid)
+	if uid == "" {
+		return uid
+	}
+	if at := strings.LastIndex(uid, "@"); at >= 0 {
+		uid = uid[:at]
+	}
+	return uid + "@" + domain
+}
+
+// fillLocationsFromGeo sets the LOCATION of events that have GEO coordinates but no LOCATION to the
+// place name returned by lookup. A failed lookup is recorded as a warning and leaves the event as it is.
+func fillLocationsFromGeo(calendar *ics.Calendar, lookup func(lat, lon float64) (string, error), fixLog *FixLog) {
+	if lookup == nil {
+		return
+	}
+
+	for _, event := range calendar.Events() {
+		if location := event.GetProperty(ics.ComponentPropertyLocation); location != nil && strings.TrimSpace(location.Value) != "" {
+			continue
+		}
+		geo := event.GetProperty(ics.ComponentPropertyGeo)
+		if geo == nil {
+			continue
+		}
+		parts := strings.Split(geo.Value, ";")
+		if len(parts) != 2 {
+			continue
+		}
+		lat, lon, ok := parseGeoCoordinates(parts[0], parts[1])
+		if !ok {
+			continue
+		}
+
+		name, err := lookup(lat, lon)
+		if err != nil {
+			fixLog.AddWarning(fmt.Sprintf("Failed to reverse geocode GEO %s, leaving LOCATION empty: %v", geo.Value, err))
+			continue
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			event.SetProperty(ics.ComponentPropertyLocation, name)
+			log.Printf("Set LOCATION of GEO %s to '%s'", geo.Value, name)
+		}
+	}
+}
+
+// anonymizeEvents reduces every event to its timing and UID with a generic summary, for sharing
+// a busy/free view of a calendar. Alarms are dropped as well since they may repeat the original summary.
+func anonymizeEvents(calendar *ics.Calendar) {
+	for _, event := range calendar.Events() {
+		properties := event.Properties[:0]
+		for _, prop := range event.Properties {
+			if anonymizedEventProperties[prop.IANAToken] {
+				properties = append(properties, prop)
+			}
+		}
+		event.Properties = properties
+		event.Components = nil
+		event.SetProperty(ics.ComponentPropertySummary, anonymizedSummary)
+	}
+
+	log.Printf("Anonymized %d events", len(calendar.Events()))
+}
+
+// selectUpcomingEvents drops events that ended before now (when upcoming is set), skips the first
+// offset events, and keeps at most limit events ordered by start time (when limit is positive).
+// Recurring events are not expanded, so a recurring series counts as a single event positioned at
+// its first occurrence, and it is only dropped once its last occurrence has ended (see seriesEnd).
+func selectUpcomingEvents(calendar *ics.Calendar, upcoming bool, offset, limit int, now time.Time, loc *time.Location) {
+	if !upcoming && offset <= 0 && limit <= 0 {
+		return
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	type timedEvent struct {
+		event *ics.VEvent
+		start time.Time
+	}
+
+	var selected []timedEvent
+	for _, event := range calendar.Events() {
+		start, end, ok := eventInterval(event, loc)
+		if ok {
+			end, ok = seriesEnd(event, start, end, loc)
+		}
+		if upcoming && ok && end.Before(now) {
+			continue
+		}
+		selected = append(selected, timedEvent{event: event, start: start})
+	}
+
+	// Events without a parseable start sort last
+	sort.SliceStable(selected, func(i, j int) bool {
+		if selected[i].start.IsZero() || selected[j].start.IsZero() {
+			return !selected[i].start.IsZero()
+		}
+		return selected[i].start.Before(selected[j].start)
+	})
+
+	if offset >= len(selected) {
+		selected = nil
+	} else if offset > 0 {
+		selected = selected[offset:]
+	}
+	if limit > 0 && len(selected) > limit {
+		selected = selected[:limit]
+	}
+
+	events := make([]*ics.VEvent, len(selected))
+	for i, timed := range selected {
+		events[i] = timed.event
+	}
+	removed := len(calendar.Events()) - len(events)
+	replaceEvents(calendar, events)
+
+	log.Printf("Selected %d upcoming events, removed %d", len(events), removed)
+}
+
+// seriesEnd returns the end of the last occurrence of an event whose first occurrence lasts from start
+// to end. An RRULE series ends one event duration after its UNTIL; a series without UNTIL, or with a
+// COUNT, which would need expanding, never ends (false). RDATE occurrences and EXDATE are considered
+// only without an RRULE, where the last RDATE or DTSTART occurrence ends the series.
+func seriesEnd(event *ics.VEvent, start, end time.Time, loc *time.Location) (time.Time, bool) {
+	duration := end.Sub(start)
+	if rrule := event.GetProperty(ics.ComponentPropertyRrule); rrule != nil {
+		for _, part := range strings.Split(rrule.Value, ";") {
+			name, value, _ := strings.Cut(part, "=")
+			if !strings.EqualFold(name, "UNTIL") {
+				continue
+			}
+			until, err := parseEventDate(value, loc)
+			if err != nil {
+				return time.Time{}, false
+			}
+			if len(value) == len("20060102") {
+				// A DATE UNTIL includes occurrences on that day
+				until = until.AddDate(0, 0, 1)
+			}
+			return until.Add(duration), true
+		}
+		return time.Time{}, false
+	}
+
+	if !hasExplicitOccurrences(event) {
+		return end, true
+	}
+	last := start
+	for _, prop := range event.GetProperties(ics.ComponentPropertyRdate) {
+		for _, occurrence := range parseOccurrenceList(prop, loc) {
+			if occurrence.After(last) {
+				last = occurrence
+			}
+		}
+	}
+	return last.Add(duration), true
+}
+
+// ErrEventNotFound is returned when ProcessOptions.UID names an event the calendar does not contain
+var ErrEventNotFound = errors.New("no event with the requested UID")
+
+// selectEventByUID reduces the calendar to the events with the given UID, i.e. the master and its
+// RECURRENCE-ID overrides, and the VTIMEZONE components they reference. TODOs, journal entries and
+// other components are dropped; calendar properties are kept.
+func selectEventByUID(calendar *ics.Calendar, uid string) error {
+	selected := make(map[*ics.VEvent]bool)
+	referenced := make(map[string]bool)
+	for _, event := range calendar.Events() {
+		if prop := event.GetProperty(ics.ComponentPropertyUniqueId); prop == nil || prop.Value != uid {
+			continue
+		}
+		selected[event] = true
+		for _, prop := range event.Properties {
+			if tzid := firstParameter(prop, ics.ParameterTzid); tzid != "" {
+				referenced[tzid] = true
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("%w: %s", ErrEventNotFound, uid)
+	}
+
+	components := make([]ics.Component, 0, len(selected))
+	for _, component := range calendar.Components {
+		switch component := component.(type) {
+		case *ics.VEvent:
+			if selected[component] {
+				components = append(components, component)
+			}
+		case *ics.VTimezone:
+			if referenced[timezoneID(component)] {
+				components = append(components, component)
+			}
+		}
+	}
+	calendar.Components = components
+	log.Printf("Selected %d events with UID %s", len(selected), uid)
+	return nil
+}
+
+// filterEventsByAnyCategory keeps only events that carry at least one of the given categories (OR).
+// Categories are matched case-insensitively; an empty list keeps all events.
+func filterEventsByAnyCategory(calendar *ics.Calendar, categories []string) {
+	if len(categories) == 0 {
+		return
+	}
+
+	var kept []*ics.VEvent
+	for _, event := range calendar.Events() {
+		eventSet := eventCategorySet(event)
+		for _, category := range categories {
+			if eventSet[strings.ToLower(category)] {
+				kept = append(kept, event)
+				break
+			}
+		}
+	}
+
+	removed := len(calendar.Events()) - len(kept)
+	replaceEvents(calendar, kept)
+	log.Printf("Filtered out %d events without any of the categories %v", removed, categories)
+}
+
+// filterEventsByAllCategories keeps only events that carry every one of the given categories (AND).
+// Categories are matched case-insensitively; an empty list keeps all events.
+func filterEventsByAllCategories(calendar *ics.Calendar, categories []string) {
+	if len(categories) == 0 {
+		return
+	}
+
+	var kept []*ics.VEvent
+	for _, event := range calendar.Events() {
+		eventSet := eventCategorySet(event)
+		hasAll := true
+		for _, category := range categories {
+			if !eventSet[strings.ToLower(category)] {
+				hasAll = false
+				break
+			}
+		}
+		if hasAll {
+			kept = append(kept, event)
+		}
+	}
+
+	removed := len(calendar.Events()) - len(kept)
+	replaceEvents(calendar, kept)
+	log.Printf("Filtered out %d events without all of the categories %v", removed, categories)
+}
+
+// LocationPattern returns a case-insensitive pattern for the location filter. Unless useRegex is
+// set, location is matched as a literal substring.
+func LocationPattern(location string, useRegex bool) (*regexp.Regexp, error) {
+	if !useRegex {
+		location = regexp.QuoteMeta(location)
+	}
+	return regexp.Compile("(?i)" + location)
+}
+
+// filterEventsByLocation keeps only events whose unescaped LOCATION matches pattern; a nil
+// pattern keeps all events
+func filterEventsByLocation(calendar *ics.Calendar, pattern *regexp.Regexp) {
+	if pattern == nil {
+		return
+	}
+
+	var kept []*ics.VEvent
+	for _, event := range calendar.Events() {
+		location := event.GetProperty(ics.ComponentPropertyLocation)
+		if location != nil && pattern.MatchString(ics.FromText(location.Value)) {
+			kept = append(kept, event)
+		}
+	}
+
+	removed := len(calendar.Events()) - len(kept)
+	replaceEvents(calendar, kept)
+	log.Printf("Filtered out %d events whose location does not match %q", removed, pattern)
+}
+
+// eventCategorySet returns the lower-cased categories of an event
+func eventCategorySet(event *ics.VEvent) map[string]bool {
+	set := make(map[string]bool)
+	for _, category := range eventCategories(event) {
+		set[strings.ToLower(category)] = true
+	}
+	return set
+}
+
+// truncateEvents keeps the first limit events of a calendar and notes the truncation in X-WR-CALDESC.
+// It reports whether events were removed.
+func truncateEvents(calendar *ics.Calendar, limit int) bool {
+	events := calendar.Events()
+	if limit <= 0 || len(events) <= limit {
+		return false
+	}
+
+	replaceEvents(calendar, events[:limit])
+
+	note := fmt.Sprintf("Truncated to the first %d of %d events", limit, len(events))
+	for _, prop := range calendar.CalendarProperties {
+		if prop.IANAToken == string(ics.PropertyXWRCalDesc) && prop.Value != "" {
+			note = prop.Value + " (" + note + ")"
+		}
+	}
+	calendar.SetXWRCalDesc(note)
+
+	log.Printf("Truncated output to %d of %d events", limit, len(events))
+	return true
+}
+
+// removeCancelledEvents drops events whose source STATUS is CANCELLED. It must run before fixing,
+// which adds STATUS:CONFIRMED to events without a STATUS.
+func removeCancelledEvents(calendar *ics.Calendar) {
+	var kept []*ics.VEvent
+	for _, event := range calendar.Events() {
+		status := event.GetProperty(ics.ComponentPropertyStatus)
+		if status != nil && strings.EqualFold(strings.TrimSpace(status.Value), string(ics.ObjectStatusCancelled)) {
+			continue
+		}
+		kept = append(kept, event)
+	}
+
+	removed := len(calendar.Events()) - len(kept)
+	if removed > 0 {
+		replaceEvents(calendar, kept)
+	}
+
+	log.Printf("Removed %d cancelled events", removed)
+}
+
+// setEventStatus sets the STATUS of every event to status, replacing the one of the feed
+func setEventStatus(calendar *ics.Calendar, status string) {
+	for _, event := range calendar.Events() {
+		event.RemoveProperty(ics.ComponentPropertyStatus)
+		event.SetProperty(ics.ComponentPropertyStatus, status)
+	}
+	log.Printf("Set STATUS of %d events to %s", len(calendar.Events()), status)
+}
+
+// setEventColor sets the COLOR of every event to color, replacing the one of the feed
+func setEventColor(calendar *ics.Calendar, color string) {
+	for _, event := range calendar.Events() {
+		event.RemoveProperty(ics.ComponentPropertyColor)
+		event.SetColor(color)
+	}
+	log.Printf("Set COLOR of %d events to %s", len(calendar.Events()), color)
+}
+
+// removeEventsByUID drops the events whose UID exactly equals one of uids. Overrides share the UID of
+// their recurring event, so they go with it.
+func removeEventsByUID(calendar *ics.Calendar, uids []string) {
+	if len(uids) == 0 {
+		return
+	}
+
+	var kept []*ics.VEvent
+	for _, event := range calendar.Events() {
+		if uid := event.GetProperty(ics.ComponentPropertyUniqueId); uid != nil && slices.Contains(uids, uid.Value) {
+			continue
+		}
+		kept = append(kept, event)
+	}
+
+	removed := len(calendar.Events()) - len(kept)
+	if removed > 0 {
+		replaceEvents(calendar, kept)
+	}
+
+	log.Printf("Removed %d events by UID", removed)
+}
+
+// replaceEvents replaces all events of a calendar with the given ones, in order.
+// Other components keep their relative order and are placed before the events.
+func replaceEvents(calendar *ics.Calendar, events []*ics.VEvent) {
+	components := make([]ics.Component, 0, len(calendar.Components))
+	for _, component := range calendar.Components {
+		if _, isEvent := component.(*ics.VEvent); !isEvent {
+			components = append(components, component)
+		}
+	}
+	for _, event := range events {
+		components = append(components, event)
+	}
+	calendar.Components = components
+}
+
+// Supported alarm modes for convertAlarms
+const (
+	AlarmsDisplay = "display"
+	AlarmsStrip   = "strip"
+)
+
+// convertAlarms rewrites the alarms of every event for clients with limited alarm support:
+// "display" turns AUDIO alarms into DISPLAY alarms showing the event summary, and "strip"
+// removes all alarms. An empty mode leaves the alarms as the fixes left them.
+func convertAlarms(calendar *ics.Calendar, mode string) {
+	if mode == "" {
+		return
+	}
+
+	changed := 0
+	for _, event := range calendar.Events() {
+		components := event.Components[:0]
+		for _, component := range event.Components {
+			alarm, isAlarm := component.(*ics.VAlarm)
+			if !isAlarm {
+				components = append(components, component)
+				continue
+			}
+			if mode == AlarmsStrip {
+				changed++
+				continue
+			}
+			if action := alarm.GetProperty(ics.ComponentPropertyAction); action != nil && strings.EqualFold(action.Value, string(ics.ActionAudio)) {
+				convertAudioAlarm(alarm, event)
+				changed++
+			}
+			components = append(components, alarm)
+		}
+		event.Components = components
+	}
+
+	if mode == AlarmsStrip {
+		log.Printf("Removed %d alarms", changed)
+	} else {
+		log.Printf("Converted %d audio alarms to display alarms", changed)
+	}
+}
+
+// convertAudioAlarm turns an AUDIO alarm into a DISPLAY alarm. The sound attachment is dropped
+// since DISPLAY alarms cannot have one, and the event summary becomes the required DESCRIPTION.
+func convertAudioAlarm(alarm *ics.VAlarm, event *ics.VEvent) {
+	alarm.SetAction(ics.ActionDisplay)
+	alarm.RemoveProperty(ics.ComponentPropertyAttach)
+	if alarm.GetProperty(ics.ComponentPropertyDescription) != nil {
+		return
+	}
+	if summary := event.GetProperty(ics.ComponentPropertySummary); summary != nil && summary.Value != "" {
+		copySummaryToAlarm(alarm, ics.ComponentPropertyDescription, summary)
+	} else {
+		alarm.SetProperty(ics.ComponentPropertyDescription, "Event Reminder")
+	}
+}
+
+// addAllDayReminders adds a display alarm lead before the start of every all-day (DATE) event.
+// Timed events are left alone, so the reminder can be applied to merged feeds without
+// duplicating the reminders of regular appointments.
+func addAllDayReminders(calendar *ics.Calendar, lead time.Duration) {
+	if lead <= 0 {
+		return
+	}
+
+	trigger := formatTriggerDuration(lead)
+	added := 0
+	for _, event := range calendar.Events() {
+		start := event.GetProperty(ics.ComponentPropertyDtStart)
+		if start == nil || !isDateValue(start) {
+			continue
+		}
+
+		description := "Event Reminder"
+		if summary := event.GetProperty(ics.ComponentPropertySummary); summary != nil && summary.Value != "" {
+			description = summary.Value
+		}
+
+		alarm := event.AddAlarm()
+		alarm.SetAction(ics.ActionDisplay)
+		alarm.SetTrigger(trigger)
+		alarm.SetDescription(description)
+		added++
+	}
+
+	log.Printf("Added %s reminders to %d all-day events", trigger, added)
+}
+
+// splitMidnightEvents replaces every timed event that crosses midnight in loc (UTC when nil) with
+// one event per day, clamped to the day's boundaries, for agenda views that list events by day.
+// The segments share the UID and are told apart by a RECURRENCE-ID of their start; only the
+// first one keeps the alarms. Recurring events and instances of them are left alone, since
+// segments would collide with their series.
+func splitMidnightEvents(calendar *ics.Calendar, loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var events []*ics.VEvent
+	split, segments := 0, 0
+	for _, event := range calendar.Events() {
+		days := splitEventAtMidnight(event, loc)
+		if len(days) > 1 {
+			split++
+			segments += len(days)
+		}
+		events = append(events, days...)
+	}
+	if split == 0 {
+		return
+	}
+
+	replaceEvents(calendar, events)
+	log.Printf("Split %d events crossing midnight into %d day segments", split, segments)
+}
+
+// splitEventAtMidnight returns the per-day segments of an event, or the event itself if it is
+// all-day, recurring, an instance, or starts and ends on the same day. An event ending exactly at
+// midnight does not reach into the following day.
+func splitEventAtMidnight(event *ics.VEvent, loc *time.Location) []*ics.VEvent {
+	unchanged := []*ics.VEvent{event}
+	startProp := event.GetProperty(ics.ComponentPropertyDtStart)
+	if startProp == nil || isDateValue(startProp) ||
+		event.GetProperty(ics.ComponentPropertyRrule) != nil ||
+		event.GetProperty(ics.ComponentPropertyRdate) != nil ||
+		event.GetProperty(ics.ComponentPropertyRecurrenceId) != nil {
+		return unchanged
+	}
+	start, err := parseEventTime(startProp, loc)
+	if err != nil {
+		return unchanged
+	}
+
+	var end time.Time
+	if endProp := event.GetProperty(ics.ComponentPropertyDtEnd); endProp != nil {
+		if isDateValue(endProp) {
+			return unchanged
+		}
+		if end, err = parseEventTime(endProp, loc); err != nil {
+			return unchanged
+		}
+	} else if durationProp := event.GetProperty(ics.ComponentPropertyDuration); durationProp != nil {
+		duration, err := ParseDuration(strings.TrimSpace(durationProp.Value))
+		if err != nil {
+			return unchanged
+		}
+		end = start.Add(duration)
+	}
+
+	startYear, startMonth, startDay := start.In(loc).Date()
+	lastYear, lastMonth, lastDay := end.Add(-time.Nanosecond).In(loc).Date()
+	if !end.After(start) || (startYear == lastYear && startMonth == lastMonth && startDay == lastDay) {
+		return unchanged
+	}
+
+	var segments []*ics.VEvent
+	for dayStart := start; dayStart.Before(end); {
+		year, month, day := dayStart.In(loc).Date()
+		dayEnd := time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+		if dayEnd.After(end) {
+			dayEnd = end
+		}
+
+		segment := cloneEvent(event, len(segments) == 0)
+		segment.RemoveProperty(ics.ComponentPropertyDuration)
+		setEventTime(segment, ics.ComponentPropertyDtStart, startProp, dayStart, loc)
+		setEventTime(segment, ics.ComponentPropertyDtEnd, startProp, dayEnd, loc)
+		setEventTime(segment, ics.ComponentPropertyRecurrenceId, startProp, dayStart, loc)
+		segments = append(segments, segment)
+		dayStart = dayEnd
+	}
+	return segments
+}
+
+// mergeAdjacentEvents merges events with the same SUMMARY whose intervals touch or overlap into the
+// earliest of them, extended to the latest end, for feeds that list one pickup as several
+// back-to-back entries. All-day and timed events are merged separately; events without DTEND and
+// recurring events and their instances are left alone. Floating times are interpreted in loc
+// (UTC when nil).
+func mergeAdjacentEvents(calendar *ics.Calendar, loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	type span struct {
+		event      *ics.VEvent
+		start, end time.Time
+	}
+	groups := make(map[string][]*span)
+	var keys []string
+	for _, event := range calendar.Events() {
+		startProp := event.GetProperty(ics.ComponentPropertyDtStart)
+		endProp := event.GetProperty(ics.ComponentPropertyDtEnd)
+		summary := event.GetProperty(ics.ComponentPropertySummary)
+		if startProp == nil || endProp == nil || summary == nil || isDateValue(startProp) != isDateValue(endProp) ||
+			event.GetProperty(ics.ComponentPropertyRrule) != nil ||
+			event.GetProperty(ics.ComponentPropertyRdate) != nil ||
+			event.GetProperty(ics.ComponentPropertyRecurrenceId) != nil {
+			continue
+		}
+		start, end, ok := eventInterval(event, loc)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%t\x00%s", isDateValue(startProp), summary.Value)
+		if _, seen := groups[key]; !seen {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], &span{event: event, start: start, end: end})
+	}
+
+	merged := make(map[*ics.VEvent]bool)
+	for _, key := range keys {
+		spans := groups[key]
+		sort.SliceStable(spans, func(i, j int) bool { return spans[i].start.Before(spans[j].start) })
+
+		current, extended := spans[0], false
+		for _, next := range spans[1:] {
+			if next.start.After(current.end) {
+				if extended {
+					setMergedEnd(current.event, current.end, loc)
+				}
+				current, extended = next, false
+				continue
+			}
+			merged[next.event] = true
+			if next.end.After(current.end) {
+				current.end, extended = next.end, true
+			}
+		}
+		if extended {
+			setMergedEnd(current.event, current.end, loc)
+		}
+	}
+	if len(merged) == 0 {
+		return
+	}
+
+	kept := slices.DeleteFunc(calendar.Events(), func(event *ics.VEvent) bool { return merged[event] })
+	replaceEvents(calendar, kept)
+	log.Printf("Merged %d adjacent events with the same SUMMARY", len(merged))
+}
+
+// setMergedEnd moves the DTEND of a merged event to end, keeping its date or time zone form
+func setMergedEnd(event *ics.VEvent, end time.Time, loc *time.Location) {
+	dtend := event.GetProperty(ics.ComponentPropertyDtEnd)
+	if isDateValue(dtend) {
+		dtend.Value = end.Format("20060102")
+		return
+	}
+	like := *dtend
+	setEventTime(event, ics.ComponentPropertyDtEnd, &like, end, loc)
+}
+
+// cloneEvent copies an event with its properties and, if withAlarms is set, its alarms
+func cloneEvent(event *ics.VEvent, withAlarms bool) *ics.VEvent {
+	clone := &ics.VEvent{}
+	for _, prop := range event.Properties {
+		prop.ICalParameters = cloneParameters(prop.ICalParameters)
+		clone.Properties = append(clone.Properties, prop)
+	}
+	for _, component := range event.Components {
+		if _, isAlarm := component.(*ics.VAlarm); withAlarms || !isAlarm {
+			clone.Components = append(clone.Components, component)
+		}
+	}
+	return clone
+}
+
+// setEventTime sets a date-time property to t in the form of like: in like's TZID, in UTC if like
+// is a UTC time, or as floating time in loc
+func setEventTime(event *ics.VEvent, property ics.ComponentProperty, like *ics.IANAProperty, t time.Time, loc *time.Location) {
+	value := t.UTC().Format("20060102T150405Z")
+	if !strings.HasSuffix(like.Value, "Z") {
+		zone := loc
+		if tzid := firstParameter(*like, ics.ParameterTzid); tzid != "" {
+			if tzLoc, err := LoadLocation(tzid); err == nil {
+				zone = tzLoc
+			}
+		}
+		value = t.In(zone).Format("20060102T150405")
+	}
+
+	event.RemoveProperty(property)
+	event.Properties = append(event.Properties, ics.IANAProperty{
+		BaseProperty: ics.BaseProperty{IANAToken: string(property), ICalParameters: cloneParameters(like.ICalParameters), Value: value},
+	})
+}
+
+// cloneParameters copies property parameters so the copy can be changed independently
+func cloneParameters(params map[string][]string) map[string][]string {
+	clone := make(map[string][]string, len(params))
+	for name, values := range params {
+		clone[name] = slices.Clone(values)
+	}
+	return clone
+}
+
+// minifiedProperties are the optional properties dropped by minifyCalendar; the fixes
+// add most of them, which minify turns off
+var minifiedProperties = map[string]bool{
+	string(ics.ComponentPropertyCreated):      true,
+	string(ics.ComponentPropertyLastModified): true,
+	string(ics.ComponentPropertySequence):     true,
+	string(ics.ComponentPropertyTransp):       true,
+	string(ics.ComponentPropertyClass):        true,
+}
+
+// minifyCalendar shrinks the calendar for bandwidth-constrained clients: events and TODOs lose
+// empty properties, the optional minifiedProperties, and X- extensions, and their alarms lose
+// X- extensions. Calendar-level X-WR properties are kept since they carry the calendar name and zone.
+// It runs after fixing, so the result still has every required property.
+func minifyCalendar(calendar *ics.Calendar) {
+	dropFromComponent := func(prop ics.IANAProperty) bool {
+		if isExtensionProperty(prop) {
+			return true
+		}
+		if slices.Contains(requiredEventProperties, ics.ComponentProperty(prop.IANAToken)) {
+			return false
+		}
+		return minifiedProperties[prop.IANAToken] || strings.TrimSpace(prop.Value) == ""
+	}
+
+	var components []*ics.ComponentBase
+	for _, event := range calendar.Events() {
+		components = append(components, &event.ComponentBase)
+	}
+	for _, todo := range calendar.Todos() {
+		components = append(components, &todo.ComponentBase)
+	}
+
+	removed := 0
+	for _, component := range components {
+		removed += removeProperties(component, dropFromComponent)
+		for _, sub := range component.Components {
+			if alarm, ok := sub.(*ics.VAlarm); ok {
+				removed += removeProperties(&alarm.ComponentBase, isExtensionProperty)
+			}
+		}
+	}
+
+	log.Printf("Minified calendar, removed %d properties", removed)
+}
+
+// isExtensionProperty reports whether a property is a non-standard X- extension
+func isExtensionProperty(prop ics.IANAProperty) bool {
+	return strings.HasPrefix(strings.ToUpper(prop.IANAToken), "X-")
+}
+
+// removeProperties removes the properties of a component for which drop returns true
+// and returns how many were removed
+func removeProperties(component *ics.ComponentBase, drop func(ics.IANAProperty) bool) int {
+	properties := component.Properties[:0]
+	for _, prop := range component.Properties {
+		if !drop(prop) {
+			properties = append(properties, prop)
+		}
+	}
+	removed := len(component.Properties) - len(properties)
+	component.Properties = properties
+	return removed
+}
+
+// formatTriggerDuration formats a lead time as a negative RFC 5545 duration such as -PT18H
+func formatTriggerDuration(lead time.Duration) string {
+	return "-" + formatDuration(lead)
+}
+
+// formatDuration formats a non-negative duration as an RFC 5545 duration such as PT1H30M,
+// rounded to whole seconds
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	hours := int(d / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	seconds := int(d % time.Minute / time.Second)
+
+	var b strings.Builder
+	b.WriteString("PT")
+	if hours > 0 {
+		fmt.Fprintf(&b, "%dH", hours)
+	}
+	if minutes > 0 {
+		fmt.Fprintf(&b, "%dM", minutes)
+	}
+	if seconds > 0 || (hours == 0 && minutes == 0) {
+		fmt.Fprintf(&b, "%dS", seconds)
+	}
+	return b.String()
+}
+
+// Units of the date and time parts of an RFC 5545 duration
+var (
+	durationDateUnits = map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}
+	durationTimeUnits = map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+)
+
+// ParseDuration parses an RFC 5545 duration such as PT1H, P1D, -PT15M, or P2W
+func ParseDuration(value string) (time.Duration, error) {
+	rest := value
+	sign := time.Duration(1)
+	if trimmed, ok := strings.CutPrefix(rest, "-"); ok {
+		sign, rest = -1, trimmed
+	} else {
+		rest = strings.TrimPrefix(rest, "+")
+	}
+	rest, ok := strings.CutPrefix(rest, "P")
+	if !ok || rest == "" {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+
+	var total time.Duration
+	inTime := false
+	for rest != "" {
+		// The T separates the time part; it may appear only once
+		if rest[0] == 'T' && !inTime {
+			inTime = true
+			rest = rest[1:]
+			if rest == "" {
+				return 0, fmt.Errorf("invalid duration: %s", value)
+			}
+			continue
+		}
+		digits := 0
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 || digits == len(rest) {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		units := durationDateUnits
+		if inTime {
+			units = durationTimeUnits
+		}
+		unit, ok := units[rest[digits]]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		n, err := strconv.Atoi(rest[:digits])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		// Reject durations beyond the range of time.Duration (about 292 years) instead of wrapping
+		if int64(n) > math.MaxInt64/int64(unit) || total > math.MaxInt64-time.Duration(n)*unit {
+			return 0, fmt.Errorf("duration out of range: %s", value)
+		}
+		total += time.Duration(n) * unit
+		rest = rest[digits+1:]
+	}
+	return sign * total, nil
+}
+
+// setRefreshInterval advertises how often clients should poll the calendar, as the RFC 7986
+// REFRESH-INTERVAL and the legacy X-PUBLISHED-TTL understood by Outlook and Apple Calendar
+func setRefreshInterval(calendar *ics.Calendar, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	properties := calendar.CalendarProperties[:0]
+	for _, prop := range calendar.CalendarProperties {
+		if prop.IANAToken != "REFRESH-INTERVAL" && prop.IANAToken != string(ics.PropertyXPublishedTTL) {
+			properties = append(properties, prop)
+		}
+	}
+
+	value := formatDuration(interval)
+	calendar.CalendarProperties = append(properties,
+		ics.CalendarProperty{BaseProperty: ics.BaseProperty{
+			IANAToken:      "REFRESH-INTERVAL",
+			ICalParameters: map[string][]string{string(ics.ParameterValue): {"DURATION"}},
+			Value:          value,
+		}},
+		ics.CalendarProperty{BaseProperty: ics.BaseProperty{IANAToken: string(ics.PropertyXPublishedTTL), Value: value}},
+	)
+}
+
+// addFreeBusy appends a VFREEBUSY component covering from until to, so that scheduling tools can
+// read busy blocks next to the event details. Overlapping and adjacent events are merged into one
+// period, clipped to the window and written in UTC as RFC 5545 requires. Transparent, cancelled,
+// and instantaneous events take no time. Recurring events count with their first occurrence,
+// as in date filtering, and events with RDATE but no RRULE with each listed occurrence.
+func addFreeBusy(calendar *ics.Calendar, from, to time.Time, loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	type period struct{ start, end time.Time }
+	var busy []period
+	for _, event := range calendar.Events() {
+		if transp := event.GetProperty(ics.ComponentPropertyTransp); transp != nil && strings.EqualFold(transp.Value, "TRANSPARENT") {
+			continue
+		}
+		if status := event.GetProperty(ics.ComponentPropertyStatus); status != nil && strings.EqualFold(status.Value, "CANCELLED") {
+			continue
+		}
+		start, end, ok := eventInterval(event, loc)
+		if !ok || !end.After(start) {
+			continue
+		}
+
+		starts := []time.Time{start}
+		if hasExplicitOccurrences(event) {
+			excluded := make(map[int64]bool)
+			for _, prop := range event.GetProperties(ics.ComponentPropertyExdate) {
+				for _, occurrence := range parseOccurrenceList(prop, loc) {
+					excluded[occurrence.Unix()] = true
+				}
+			}
+			for _, prop := range event.GetProperties(ics.ComponentPropertyRdate) {
+				starts = append(starts, parseOccurrenceList(prop, loc)...)
+			}
+			starts = slices.DeleteFunc(starts, func(t time.Time) bool { return excluded[t.Unix()] })
+		}
+
+		for _, occurrenceStart := range starts {
+			occurrenceEnd := occurrenceStart.Add(end.Sub(start))
+			if occurrenceStart.Before(from) {
+				occurrenceStart = from
+			}
+			if occurrenceEnd.After(to) {
+				occurrenceEnd = to
+			}
+			if occurrenceEnd.After(occurrenceStart) {
+				busy = append(busy, period{occurrenceStart, occurrenceEnd})
+			}
+		}
+	}
+
+	sort.Slice(busy, func(i, j int) bool { return busy[i].start.Before(busy[j].start) })
+	var merged []period
+	for _, p := range busy {
+		if last := len(merged) - 1; last >= 0 && !p.start.After(merged[last].end) {
+			if p.end.After(merged[last].end) {
+				merged[last].end = p.end
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+
+	const utcFormat = "20060102T150405Z"
+	freeBusy := calendar.AddBusy(generateUID())
+	freeBusy.SetProperty(ics.ComponentPropertyDtstamp, nowFunc().UTC().Format(utcFormat))
+	freeBusy.SetProperty(ics.ComponentPropertyDtStart, from.UTC().Format(utcFormat))
+	freeBusy.SetProperty(ics.ComponentPropertyDtEnd, to.UTC().Format(utcFormat))
+	if len(merged) > 0 {
+		periods := make([]string, len(merged))
+		for i, p := range merged {
+			periods[i] = p.start.UTC().Format(utcFormat) + "/" + p.end.UTC().Format(utcFormat)
+		}
+		freeBusy.SetProperty(ics.ComponentPropertyFreebusy, strings.Join(periods, ","), &ics.KeyValues{Key: string(ics.ParameterFbtype), Value: []string{string(ics.FreeBusyTimeTypeBusy)}})
+	}
+	log.Printf("Added VFREEBUSY with %d busy periods", len(merged))
+}
+
+// pruneDanglingRelations removes RELATED-TO properties whose UID no longer names an event, to-do,
+// or journal entry in the calendar, e.g. a parent event that a filter dropped. References to
+// components that are still present are left intact.
+func pruneDanglingRelations(calendar *ics.Calendar) {
+	var components []*ics.ComponentBase
+	for _, component := range calendar.Components {
+		switch c := component.(type) {
+		case *ics.VEvent:
+			components = append(components, &c.ComponentBase)
+		case *ics.VTodo:
+			components = append(components, &c.ComponentBase)
+		case *ics.VJournal:
+			components = append(components, &c.ComponentBase)
+		}
+	}
+
+	uids := make(map[string]bool)
+	for _, component := range components {
+		if uid := component.GetProperty(ics.ComponentPropertyUniqueId); uid != nil {
+			uids[strings.TrimSpace(uid.Value)] = true
+		}
+	}
+
+	for _, component := range components {
+		owner := "component without UID"
+		if uid := component.GetProperty(ics.ComponentPropertyUniqueId); uid != nil {
+			owner = uid.Value
+		}
+		removeProperties(component, func(prop ics.IANAProperty) bool {
+			if prop.IANAToken != string(ics.ComponentPropertyRelatedTo) || uids[strings.TrimSpace(prop.Value)] {
+				return false
+			}
+			log.Printf("Pruned RELATED-TO %s of %s: no component with that UID in the output", prop.Value, owner)
+			return true
+		})
+	}
+}
@@ -1,7 +1,10 @@
-package main
+package icalfix
 
 import (
+	"strconv"
 	"strings"
+
+	ics "github.com/arran4/golang-ical"
 )
 
 // RFC 5545 property value validation functions
@@ -23,11 +26,20 @@ func isValidClassValue(value string) bool {
 	return false
 }
 
-// isValidStatusValue validates STATUS property values according to RFC 5545
-func isValidStatusValue(value string) bool {
-	// RFC 5545: statvalue-event = "TENTATIVE" / "CONFIRMED" / "CANCELLED"
-	standardValues := []string{"TENTATIVE", "CONFIRMED", "CANCELLED"}
-	for _, valid := range standardValues {
+// statusValues lists the STATUS values RFC 5545 allows for each component type; they overlap
+// only in CANCELLED, so a value valid for one component is not necessarily valid for another
+var statusValues = map[ics.ComponentType][]string{
+	// statvalue-event = "TENTATIVE" / "CONFIRMED" / "CANCELLED"
+	ics.ComponentVEvent: {"TENTATIVE", "CONFIRMED", "CANCELLED"},
+	// statvalue-todo = "NEEDS-ACTION" / "COMPLETED" / "IN-PROCESS" / "CANCELLED"
+	ics.ComponentVTodo: {"NEEDS-ACTION", "COMPLETED", "IN-PROCESS", "CANCELLED"},
+	// statvalue-jour = "DRAFT" / "FINAL" / "CANCELLED"
+	ics.ComponentVJournal: {"DRAFT", "FINAL", "CANCELLED"},
+}
+
+// isValidStatusValue validates a STATUS property value of the given component type according to RFC 5545
+func isValidStatusValue(component ics.ComponentType, value string) bool {
+	for _, valid := range statusValues[component] {
 		if strings.EqualFold(value, valid) {
 			return true
 		}
@@ -70,3 +82,27 @@ func isValidActionValue(value string) bool {
 	}
 	return false
 }
+
+// isValidGeoValue validates GEO property values according to RFC 5545
+func isValidGeoValue(value string) bool {
+	// RFC 5545: geovalue = float ";" float (latitude and longitude, without spaces)
+	parts := strings.Split(value, ";")
+	if len(parts) != 2 {
+		return false
+	}
+	_, _, ok := parseGeoCoordinates(parts[0], parts[1])
+	return ok
+}
+
+// parseGeoCoordinates parses a latitude and longitude and checks that they are in range
+func parseGeoCoordinates(latValue, lonValue string) (float64, float64, bool) {
+	lat, err := strconv.ParseFloat(latValue, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, false
+	}
+	lon, err := strconv.ParseFloat(lonValue, 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
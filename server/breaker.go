@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// circuitOpenError is returned instead of fetching from a host whose circuit breaker is open
+type circuitOpenError struct {
+	host string
+	// wait is the time until the next probe of the host
+	wait time.Duration
+}
+
+func (e circuitOpenError) Error() string {
+	return fmt.Sprintf("upstream %s keeps failing, requests to it are paused for %s", e.host, e.wait.Round(time.Second))
+}
+
+// breakerFailures is the number of consecutive failed fetches from a host within breakerWindow
+// that opens its circuit; zero disables the breaker. Configured via UPSTREAM_BREAKER_FAILURES.
+var breakerFailures = 5
+
+// breakerWindow is the time in which breakerFailures failures must occur to open the circuit.
+// Configured via UPSTREAM_BREAKER_WINDOW.
+var breakerWindow = time.Minute
+
+// breakerCooldown is how long an open circuit rejects requests before a single probe is let
+// through. Configured via UPSTREAM_BREAKER_COOLDOWN.
+var breakerCooldown = 30 * time.Second
+
+// Circuit states, also the values of the state metric
+const (
+	circuitClosed   = 0
+	circuitHalfOpen = 1
+	circuitOpen     = 2
+)
+
+// hostCircuit tracks the recent failures of one upstream host
+type hostCircuit struct {
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	state        int
+}
+
+// circuitBreaker stops fetching from upstream hosts that keep failing, so that a down origin
+// costs a fast 503 instead of retries and timeouts on every request. Hosts are only tracked
+// while they fail; a successful fetch forgets them.
+type circuitBreaker struct {
+	mu    sync.Mutex
+	hosts map[string]*hostCircuit
+}
+
+var upstreamBreaker = &circuitBreaker{hosts: make(map[string]*hostCircuit)}
+
+// allow reports whether a fetch from host may proceed. After the cooldown an open circuit
+// half-opens and lets one probe through; other requests are rejected until it reports back, or
+// until another cooldown has passed in case it never does. It returns the time left until the next probe when the fetch is rejected.
+func (cb *circuitBreaker) allow(host string, now time.Time) (bool, time.Duration) {
+	if breakerFailures <= 0 {
+		return true, 0
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	circuit, ok := cb.hosts[host]
+	if !ok || circuit.state == circuitClosed {
+		return true, 0
+	}
+	if circuit.state == circuitOpen {
+		if wait := circuit.openedAt.Add(breakerCooldown).Sub(now); wait > 0 {
+			return false, wait
+		}
+		circuit.state = circuitHalfOpen
+		circuit.openedAt = now
+		return true, 0
+	}
+	// A probe is in flight; let another one through if it never reported back
+	if wait := circuit.openedAt.Add(breakerCooldown).Sub(now); wait > 0 {
+		return false, wait
+	}
+	circuit.openedAt = now
+	return true, 0
+}
+
+// record updates the circuit of host with the outcome of a fetch
+func (cb *circuitBreaker) record(host string, failed bool, now time.Time) {
+	if breakerFailures <= 0 {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if !failed {
+		delete(cb.hosts, host)
+		return
+	}
+
+	// Forget hosts whose last failures are too old to count, so the map only holds failing hosts
+	for key, circuit := range cb.hosts {
+		if circuit.state == circuitClosed && now.Sub(circuit.firstFailure) > breakerWindow {
+			delete(cb.hosts, key)
+		}
+	}
+
+	circuit, ok := cb.hosts[host]
+	if !ok {
+		circuit = &hostCircuit{firstFailure: now}
+		cb.hosts[host] = circuit
+	}
+	circuit.failures++
+	if circuit.state == circuitHalfOpen || circuit.failures >= breakerFailures {
+		circuit.state = circuitOpen
+		circuit.openedAt = now
+	}
+}
+
+// upstreamHost returns the host a feed URL is fetched from, the key of its circuit
+func upstreamHost(feedURL string) string {
+	parsed, err := url.Parse(feedURL)
+	if err != nil {
+		return feedURL
+	}
+	return strings.ToLower(parsed.Host)
+}
+
+// metrics returns the state and failure count of every tracked host in the Prometheus text format
+func (cb *circuitBreaker) metrics() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	hosts := make([]string, 0, len(cb.hosts))
+	for host := range cb.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var b strings.Builder
+	b.WriteString("# HELP ical_proxy_upstream_circuit_state Circuit breaker state of failing upstream hosts (0 closed, 1 half-open, 2 open)\n")
+	b.WriteString("# TYPE ical_proxy_upstream_circuit_state gauge\n")
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "ical_proxy_upstream_circuit_state{host=%q} %d\n", host, cb.hosts[host].state)
+	}
+	b.WriteString("# HELP ical_proxy_upstream_failures Consecutive failed fetches of failing upstream hosts\n")
+	b.WriteString("# TYPE ical_proxy_upstream_failures gauge\n")
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "ical_proxy_upstream_failures{host=%q} %d\n", host, cb.hosts[host].failures)
+	}
+	return b.String()
+}
+
+// handleMetrics exposes the circuit breaker state in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := io.WriteString(w, upstreamBreaker.metrics()); err != nil {
+		log.Printf("Failed to write metrics: %v", err)
+	}
+}
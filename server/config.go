@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/konairius/ical-proxy/pkg/icalfix"
+)
+
+// configSettings lists the environment variables that can also be set in the CONFIG_FILE.
+// In the file they are written in lower case, e.g. {"upstream_timeout": "1m"}.
+var configSettings = []string{
+	"PORT",
+	"BIND_ADDR",
+	"BASE_PATH",
+	"DISABLE_INDEX",
+	"TLS_CERT",
+	"TLS_KEY",
+	"PROXY_MIN_REFRESH_INTERVAL",
+	"REQUEST_TIMEOUT",
+	"UPSTREAM_TIMEOUT",
+	"UPSTREAM_RETRIES",
+	"UPSTREAM_BREAKER_FAILURES",
+	"UPSTREAM_BREAKER_WINDOW",
+	"UPSTREAM_BREAKER_COOLDOWN",
+	"MAX_ICAL_BYTES",
+	"MAX_OUTPUT_EVENTS",
+	"HEALTHCHECK_URL",
+	"GEOCODER_URL",
+	"PROXY_API_KEY",
+	"DEFAULT_PRODID",
+	"DEFAULT_SUMMARY",
+}
+
+// Config is the server configuration, read once at startup from CONFIG_FILE and the environment
+type Config struct {
+	Addr         string
+	BasePath     string
+	DisableIndex bool
+	TLSCert      string
+	TLSKey       string
+	APIKey       string
+
+	MinRefreshInterval time.Duration
+	RequestTimeout     time.Duration
+	UpstreamTimeout    time.Duration
+	UpstreamRetries    int
+	BreakerFailures    int
+	BreakerWindow      time.Duration
+	BreakerCooldown    time.Duration
+	MaxICalBytes       int64
+	MaxOutputEvents    int
+	HealthcheckURL     string
+	GeocoderURL        string
+	DefaultProdID      string
+	DefaultSummary     string
+}
+
+// loadConfig reads the settings from the JSON file named by CONFIG_FILE, if any, lets environment
+// variables override them, and validates the result. Errors name the offending setting and its source.
+func loadConfig(getenv func(string) string) (*Config, error) {
+	values := make(map[string]string)
+	sources := make(map[string]string)
+
+	if path := getenv("CONFIG_FILE"); path != "" {
+		fileValues, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range fileValues {
+			values[name] = value
+			sources[name] = fmt.Sprintf("%s in %s", strings.ToLower(name), path)
+		}
+	}
+	for _, name := range configSettings {
+		if value := getenv(name); value != "" {
+			values[name] = value
+			sources[name] = name
+		}
+	}
+
+	cfg := &Config{
+		MinRefreshInterval: minRefreshInterval,
+		RequestTimeout:     requestTimeout,
+		UpstreamTimeout:    upstreamTimeout,
+		UpstreamRetries:    upstreamRetries,
+		BreakerFailures:    breakerFailures,
+		BreakerWindow:      breakerWindow,
+		BreakerCooldown:    breakerCooldown,
+		MaxICalBytes:       maxICalBytes,
+		MaxOutputEvents:    maxOutputEvents,
+		HealthcheckURL:     healthcheckURL,
+		GeocoderURL:        geocoderURL,
+		DefaultProdID:      icalfix.DefaultProdID,
+		DefaultSummary:     icalfix.DefaultSummary,
+	}
+
+	invalid := func(name, hint string) error {
+		return fmt.Errorf("invalid %s %q: %s", sources[name], values[name], hint)
+	}
+
+	if value := values["PROXY_MIN_REFRESH_INTERVAL"]; value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return nil, invalid("PROXY_MIN_REFRESH_INTERVAL", "use a duration like 30s or 5m")
+		}
+		cfg.MinRefreshInterval = interval
+	}
+
+	if value := values["REQUEST_TIMEOUT"]; value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, invalid("REQUEST_TIMEOUT", "use a duration like 25s or 1m")
+		}
+		cfg.RequestTimeout = timeout
+	}
+
+	if value := values["UPSTREAM_TIMEOUT"]; value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, invalid("UPSTREAM_TIMEOUT", "use a duration like 30s or 1m")
+		}
+		cfg.UpstreamTimeout = timeout
+	}
+
+	if value := values["UPSTREAM_RETRIES"]; value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return nil, invalid("UPSTREAM_RETRIES", "use a non-negative integer")
+		}
+		cfg.UpstreamRetries = retries
+	}
+
+	if value := values["UPSTREAM_BREAKER_FAILURES"]; value != "" {
+		failures, err := strconv.Atoi(value)
+		if err != nil || failures < 0 {
+			return nil, invalid("UPSTREAM_BREAKER_FAILURES", "use a non-negative integer")
+		}
+		cfg.BreakerFailures = failures
+	}
+
+	if value := values["UPSTREAM_BREAKER_WINDOW"]; value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return nil, invalid("UPSTREAM_BREAKER_WINDOW", "use a duration like 1m")
+		}
+		cfg.BreakerWindow = window
+	}
+
+	if value := values["UPSTREAM_BREAKER_COOLDOWN"]; value != "" {
+		cooldown, err := time.ParseDuration(value)
+		if err != nil || cooldown <= 0 {
+			return nil, invalid("UPSTREAM_BREAKER_COOLDOWN", "use a duration like 30s")
+		}
+		cfg.BreakerCooldown = cooldown
+	}
+
+	if value := values["MAX_ICAL_BYTES"]; value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			return nil, invalid("MAX_ICAL_BYTES", "use a positive number of bytes")
+		}
+		cfg.MaxICalBytes = limit
+	}
+
+	if value := values["MAX_OUTPUT_EVENTS"]; value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return nil, invalid("MAX_OUTPUT_EVENTS", "use a non-negative number of events")
+		}
+		cfg.MaxOutputEvents = limit
+	}
+
+	if value := values["HEALTHCHECK_URL"]; value != "" {
+		if parsed, err := url.Parse(value); err != nil || !parsed.IsAbs() {
+			return nil, invalid("HEALTHCHECK_URL", "use an absolute URL")
+		}
+		cfg.HealthcheckURL = value
+	}
+
+	if value := values["GEOCODER_URL"]; value != "" {
+		parsed, err := url.Parse(value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			!strings.Contains(value, "{lat}") || !strings.Contains(value, "{lon}") {
+			return nil, invalid("GEOCODER_URL", "use an http or https URL with {lat} and {lon} placeholders")
+		}
+		cfg.GeocoderURL = value
+	}
+
+	if value := values["DEFAULT_PRODID"]; value != "" {
+		prodID, err := icalfix.FormatProdID(value)
+		if err != nil {
+			return nil, invalid("DEFAULT_PRODID", err.Error())
+		}
+		cfg.DefaultProdID = prodID
+	}
+
+	if value := values["DEFAULT_SUMMARY"]; value != "" {
+		summary, ok := formatSummary(value)
+		if !ok {
+			return nil, invalid("DEFAULT_SUMMARY", "use a non-empty title without control characters")
+		}
+		cfg.DefaultSummary = summary
+	}
+
+	port := values["PORT"]
+	if port == "" {
+		port = "8080"
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return nil, invalid("PORT", "use a TCP port between 1 and 65535")
+	}
+
+	// BIND_ADDR selects the interface as well, e.g. 127.0.0.1:8080, and takes precedence over PORT
+	cfg.Addr = ":" + port
+	if value := values["BIND_ADDR"]; value != "" {
+		cfg.Addr = value
+	}
+
+	if value := values["BASE_PATH"]; value != "" {
+		basePath, err := formatBasePath(value)
+		if err != nil {
+			return nil, invalid("BASE_PATH", err.Error())
+		}
+		cfg.BasePath = basePath
+	}
+
+	// The key is not repeated in the error, so that it does not end up in logs
+	if value := values["PROXY_API_KEY"]; value != "" {
+		if strings.IndexFunc(value, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+			return nil, fmt.Errorf("invalid %s: use a key without spaces or control characters", sources["PROXY_API_KEY"])
+		}
+		cfg.APIKey = value
+	}
+
+	if value := values["DISABLE_INDEX"]; value != "" {
+		disable, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalid("DISABLE_INDEX", "use true or false")
+		}
+		cfg.DisableIndex = disable
+	}
+
+	cfg.TLSCert, cfg.TLSKey = values["TLS_CERT"], values["TLS_KEY"]
+	if err := checkTLSFiles(cfg.TLSCert, cfg.TLSKey); err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// formatBasePath validates a BASE_PATH and brings it into the form "/calendar", without a trailing
+// slash, so that routes can be appended to it; "/" means no prefix
+func formatBasePath(value string) (string, error) {
+	if !strings.HasPrefix(value, "/") {
+		return "", fmt.Errorf("use a path starting with /, like /calendar")
+	}
+	if strings.ContainsAny(value, "?#{} \t") || strings.Contains(value, "//") {
+		return "", fmt.Errorf("use a plain path like /calendar without query, spaces, or empty segments")
+	}
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+// readConfigFile reads a flat JSON object of settings. Keys are the lower-case names of
+// configSettings; values may be strings, numbers, or booleans. Unknown keys are rejected
+// so that typos do not silently fall back to defaults.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- the path comes from the operator's CONFIG_FILE
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONFIG_FILE: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse CONFIG_FILE %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, message := range raw {
+		name := strings.ToUpper(key)
+		if !isConfigSetting(name) {
+			return nil, fmt.Errorf("unknown setting %q in %s", key, path)
+		}
+
+		var value any
+		if err := json.Unmarshal(message, &value); err != nil {
+			return nil, fmt.Errorf("invalid %s in %s: %w", key, path, err)
+		}
+		switch v := value.(type) {
+		case string:
+			values[name] = v
+		case float64, bool:
+			values[name] = strings.TrimSpace(string(message))
+		default:
+			return nil, fmt.Errorf("invalid %s in %s: use a string, number, or boolean", key, path)
+		}
+	}
+	return values, nil
+}
+
+// isConfigSetting reports whether name is one of configSettings
+func isConfigSetting(name string) bool {
+	for _, setting := range configSettings {
+		if setting == name {
+			return true
+		}
+	}
+	return false
+}
+
+// apply stores the configuration in the package settings used by the handlers
+func (cfg *Config) apply() {
+	minRefreshInterval = cfg.MinRefreshInterval
+	requestTimeout = cfg.RequestTimeout
+	upstreamTimeout = cfg.UpstreamTimeout
+	upstreamRetries = cfg.UpstreamRetries
+	breakerFailures = cfg.BreakerFailures
+	breakerWindow = cfg.BreakerWindow
+	breakerCooldown = cfg.BreakerCooldown
+	maxICalBytes = cfg.MaxICalBytes
+	maxOutputEvents = cfg.MaxOutputEvents
+	healthcheckURL = cfg.HealthcheckURL
+	geocoderURL = cfg.GeocoderURL
+	icalfix.DefaultProdID = cfg.DefaultProdID
+	icalfix.DefaultSummary = cfg.DefaultSummary
+}
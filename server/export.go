@@ -0,0 +1,55 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"log"
+	"sort"
+
+	"github.com/konairius/ical-proxy/pkg/icalfix"
+)
+
+// Supported output formats and split modes for the 'format' and 'split' query parameters
+const (
+	formatICS     = "ics"
+	formatZip     = "zip"
+	formatNDJSON  = "ndjson"
+	splitCategory = "category"
+)
+
+// buildCalendarZip packs a processed calendar into a zip archive. Without a split mode the archive
+// holds a single calendar.ics; with split=category it holds one calendar per category, and an
+// event with several categories appears in each of their calendars.
+func buildCalendarZip(icalData string, split string) ([]byte, error) {
+	files := map[string]string{"calendar.ics": icalData}
+	if split == splitCategory {
+		var err error
+		if files, err = icalfix.SplitCalendarByCategory(icalData); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range names {
+		entry, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := entry.Write([]byte(files[name])); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Built zip archive with %d calendars", len(names))
+	return buf.Bytes(), nil
+}
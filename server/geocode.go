@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// geocoderURL is the reverse geocoding endpoint used by geocode=true, with {lat} and {lon}
+// placeholders, e.g. https://nominatim.openstreetmap.org/reverse?format=jsonv2&lat={lat}&lon={lon}.
+// Empty disables geocoding. Configured via GEOCODER_URL.
+var geocoderURL string
+
+// geocodeTimeout bounds a single lookup, so a slow provider cannot use up the request timeout
+const geocodeTimeout = 5 * time.Second
+
+// maxGeocodeLookups is the number of uncached lookups a single request may make; further events
+// keep an empty LOCATION, so a large feed does not send a burst of requests to the provider
+const maxGeocodeLookups = 20
+
+// Place names rarely change, so they are cached for a day. Failures are cached as well, for a
+// shorter time, so that coordinates the provider cannot resolve are not retried on every request.
+const (
+	geocodeCacheTTL        = 24 * time.Hour
+	geocodeFailureCacheTTL = 10 * time.Minute
+	maxGeocodeCacheEntries = 10000
+)
+
+// errGeocodeLimit is returned for lookups beyond maxGeocodeLookups in a request
+var errGeocodeLimit = errors.New("lookup limit of the request reached")
+
+// geocodeResult is a cached lookup; an empty name is a cached failure
+type geocodeResult struct {
+	name    string
+	expires time.Time
+}
+
+// geocodeCache remembers place names per coordinates, rounded to about a meter
+type geocodeCache struct {
+	mu      sync.Mutex
+	results map[string]geocodeResult
+}
+
+var placeNames = &geocodeCache{results: make(map[string]geocodeResult)}
+
+// get returns the cached result for key; ok is false if there is none or it has expired
+func (gc *geocodeCache) get(key string, now time.Time) (geocodeResult, bool) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	result, ok := gc.results[key]
+	if !ok || !now.Before(result.expires) {
+		return geocodeResult{}, false
+	}
+	return result, true
+}
+
+// put caches a result, dropping expired entries first and everything if the cache is still full
+func (gc *geocodeCache) put(key string, result geocodeResult, now time.Time) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	if len(gc.results) >= maxGeocodeCacheEntries {
+		for k, cached := range gc.results {
+			if !now.Before(cached.expires) {
+				delete(gc.results, k)
+			}
+		}
+		if len(gc.results) >= maxGeocodeCacheEntries {
+			gc.results = make(map[string]geocodeResult)
+		}
+	}
+	gc.results[key] = result
+}
+
+// reverseGeocoder returns the lookup function for icalfix.ProcessOptions.ReverseGeocode of one
+// request. It answers from the cache where possible and stops querying the provider after
+// maxGeocodeLookups lookups or once ctx is done.
+func reverseGeocoder(ctx context.Context) func(lat, lon float64) (string, error) {
+	lookups := 0
+	return func(lat, lon float64) (string, error) {
+		key := fmt.Sprintf("%.5f;%.5f", lat, lon)
+		now := time.Now()
+		if result, ok := placeNames.get(key, now); ok {
+			if result.name == "" {
+				return "", fmt.Errorf("lookup of %s failed recently", key)
+			}
+			return result.name, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if lookups >= maxGeocodeLookups {
+			return "", errGeocodeLimit
+		}
+		lookups++
+
+		name, err := lookupPlaceName(ctx, lat, lon)
+		if err != nil {
+			// A lookup cut short by the request's own deadline says nothing about the coordinates
+			if ctx.Err() == nil {
+				placeNames.put(key, geocodeResult{expires: now.Add(geocodeFailureCacheTTL)}, now)
+			}
+			return "", err
+		}
+		placeNames.put(key, geocodeResult{name: name, expires: now.Add(geocodeCacheTTL)}, now)
+		return name, nil
+	}
+}
+
+// lookupPlaceName queries geocoderURL for the coordinates. The provider must answer with a JSON
+// object whose display_name is the place name, as Nominatim and compatible services do.
+func lookupPlaceName(ctx context.Context, lat, lon float64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, geocodeTimeout)
+	defer cancel()
+
+	lookupURL := strings.NewReplacer(
+		"{lat}", strconv.FormatFloat(lat, 'f', -1, 64),
+		"{lon}", strconv.FormatFloat(lon, 'f', -1, 64),
+	).Replace(geocoderURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lookupURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	// Public providers like Nominatim require an identifying User-Agent
+	req.Header.Set("User-Agent", "ical-proxy/"+Version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Error closing geocoder response body: %v", closeErr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("geocoder returned status %d", resp.StatusCode)
+	}
+
+	var place struct {
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&place); err != nil {
+		return "", fmt.Errorf("invalid geocoder response: %w", err)
+	}
+	if strings.TrimSpace(place.DisplayName) == "" {
+		return "", errors.New("geocoder returned no place name")
+	}
+	return place.DisplayName, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// indexTemplate is the page served at the root, so that first-time visitors see how to use the
+// proxy instead of a 404. Links and the form are relative, so they also work below a BASE_PATH.
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>iCal Proxy</title>
+<style>
+body { font-family: sans-serif; max-width: 46rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; }
+code { background: #f2f2f2; padding: 0 .2rem; }
+label { display: block; margin-top: .6rem; }
+input[type=text], input[type=url], input[type=date] { width: 100%; box-sizing: border-box; padding: .3rem; }
+td { padding: .2rem .6rem .2rem 0; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>iCal Proxy</h1>
+<p>This server fetches an iCal feed, fixes common RFC 5545 compliance issues, and returns the corrected calendar.
+Subscribe your calendar app to <code>proxy?url=&lt;feed URL&gt;</code> on this server instead of the feed itself.</p>
+
+<h2>Build a proxy URL</h2>
+<form method="get" action="proxy">
+<label>Feed URL <input type="url" name="url" required placeholder="https://example.com/calendar.ics"></label>
+<label>From (optional) <input type="date" name="from"></label>
+<label>To (optional) <input type="date" name="to"></label>
+<label>Categories, comma-separated (optional) <input type="text" name="category"></label>
+<label>Location contains (optional) <input type="text" name="location"></label>
+<label><input type="checkbox" name="hide_cancelled" value="true"> Hide cancelled events</label>
+<label><input type="checkbox" name="upcoming" value="true"> Only upcoming events</label>
+<p><button type="submit">Open proxied calendar</button></p>
+</form>
+<p>The address of the opened calendar is the URL to subscribe to. Empty fields are ignored.</p>
+
+<h2>Query parameters</h2>
+<table>
+<tr><td><code>url</code></td><td>The iCal feed to fix (http, https, or webcal); required</td></tr>
+<tr><td><code>from</code>, <code>to</code>, <code>filter_tz</code></td><td>Keep events in a date range (YYYY-MM-DD), optionally in a time zone</td></tr>
+<tr><td><code>window</code></td><td>Keep events from now through a relative window like 30d, 2w, or 6mo</td></tr>
+<tr><td><code>category</code>, <code>category_all</code></td><td>Keep events with any or all of the listed categories</td></tr>
+<tr><td><code>location</code>, <code>regex</code></td><td>Keep events whose location contains the text or matches the expression</td></tr>
+<tr><td><code>upcoming</code>, <code>limit</code>, <code>offset</code></td><td>Keep upcoming events and page through them</td></tr>
+<tr><td><code>hide_cancelled</code></td><td>Drop cancelled events</td></tr>
+<tr><td><code>only</code>, <code>strip</code></td><td>Keep or remove event properties</td></tr>
+<tr><td><code>name</code>, <code>color</code></td><td>Set the calendar's display name and color</td></tr>
+<tr><td><code>disable</code></td><td>Skip individual event fixes</td></tr>
+<tr><td><code>format</code>, <code>dry_run</code></td><td>Return a zip archive, or a JSON report of the fixes</td></tr>
+</table>
+<p>Calendar files can also be posted to <code>fix</code>. Service status is at <a href="health">health</a>,
+build information at <a href="version">version</a>. See the project README for all parameters.</p>
+<p><small>ical-proxy {{.Version}}</small></p>
+</body>
+</html>
+`))
+
+// indexPage holds the values shown on the index page
+type indexPage struct {
+	Version string
+}
+
+// handleIndex serves a short usage page with a form that builds a proxy URL.
+// It can be turned off with DISABLE_INDEX for API-only deployments.
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := indexTemplate.Execute(w, indexPage{Version: Version}); err != nil {
+		log.Printf("Failed to write index page: %v", err)
+	}
+}
@@ -1,49 +1,193 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+	// Embed the time zone database since the runtime image ships without zoneinfo
+	_ "time/tzdata"
+
+	"github.com/konairius/ical-proxy/pkg/icalfix"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
 
-	ics "github.com/arran4/golang-ical"
+// Build information, injected at build time via
+// -ldflags "-X main.Version=... -X main.Commit=... -X main.BuildTime=..."
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
 )
 
 func main() {
-	http.HandleFunc("/proxy", handleProxy)
-	http.HandleFunc("/health", handleHealth)
+	cfg, err := loadConfig(os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	cfg.apply()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	shutdown, err := setupTracing(context.Background(), os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid tracing configuration: %v", err)
 	}
 
 	// Create server with timeouts to address gosec G114
 	server := &http.Server{
-		Addr:           ":" + port,
-		Handler:        nil,
+		Addr:           cfg.Addr,
+		Handler:        newServeMux(cfg),
 		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		WriteTimeout:   cfg.RequestTimeout + writeTimeoutMargin,
 		IdleTimeout:    15 * time.Second,
 		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
 
-	fmt.Printf("Starting server on port %s\n", port)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Failed to start server on port %s: %v", port, err)
+	// Stop on SIGINT or SIGTERM, letting running requests finish and flushing buffered spans
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLSCert != "" {
+			fmt.Printf("Starting ical-proxy %s (commit %s, built %s) on %s with TLS\n", Version, Commit, BuildTime, cfg.Addr)
+			serveErr <- server.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+			return
+		}
+		fmt.Printf("Starting ical-proxy %s (commit %s, built %s) on %s\n", Version, Commit, BuildTime, cfg.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		shutdownTracing(shutdown)
+		log.Fatalf("Failed to start server on %s: %v", cfg.Addr, err)
+	case <-ctx.Done():
+		log.Printf("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout+writeTimeoutMargin)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down the server: %v", err)
+		}
+		shutdownTracing(shutdown)
+	}
+}
+
+// newServeMux registers the handlers under the BASE_PATH, e.g. /calendar/proxy for a proxy mounted
+// at /calendar/ on a shared domain. /health is also kept at the root so probes need no prefix.
+// With PROXY_API_KEY set, /proxy requires the key.
+func newServeMux(cfg *Config) *http.ServeMux {
+	basePath := cfg.BasePath
+	mux := http.NewServeMux()
+	proxy := handleProxy
+	if cfg.APIKey != "" {
+		proxy = requireAPIKey(cfg.APIKey, handleProxy)
+	}
+	mux.HandleFunc(basePath+"/proxy", proxy)
+	mux.HandleFunc(basePath+"/fix", handleFix)
+	mux.HandleFunc(basePath+"/health", handleHealth)
+	mux.HandleFunc(basePath+"/version", handleVersion)
+	mux.HandleFunc(basePath+"/metrics", handleMetrics)
+	if basePath != "" {
+		mux.HandleFunc("/health", handleHealth)
+	}
+	// {$} matches the index itself only, so unknown paths still answer 404
+	if !cfg.DisableIndex {
+		mux.HandleFunc(basePath+"/{$}", handleIndex)
+	}
+	return mux
+}
+
+// requireAPIKey answers 401 Unauthorized unless the request carries key in the X-API-Key header or
+// the key query parameter. Both sides are hashed before the constant-time comparison, so response
+// times reveal neither the key nor its length.
+func requireAPIKey(key string, next http.HandlerFunc) http.HandlerFunc {
+	want := sha256.Sum256([]byte(key))
+	return func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get("X-API-Key")
+		if given == "" {
+			given = r.URL.Query().Get("key")
+		}
+		got := sha256.Sum256([]byte(given))
+		if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			http.Error(w, "Missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkTLSFiles verifies that TLS_CERT and TLS_KEY are either both unset (plain HTTP)
+// or both point to readable files, so a misconfiguration fails at startup
+func checkTLSFiles(certFile, keyFile string) error {
+	if certFile == "" && keyFile == "" {
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("TLS_CERT and TLS_KEY must be set together")
+	}
+	files := []struct{ name, path string }{{"TLS_CERT", certFile}, {"TLS_KEY", keyFile}}
+	for _, file := range files {
+		info, err := os.Stat(file.path)
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", file.name, file.path, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s %q is a directory", file.name, file.path)
+		}
+	}
+	return nil
+}
+
+// requestTimeout bounds the total time spent on a /proxy or /fix request: fetching, processing,
+// and serializing. Configured via REQUEST_TIMEOUT.
+var requestTimeout = 25 * time.Second
+
+// writeTimeoutMargin keeps the server's WriteTimeout above requestTimeout, so that a request
+// running out of time can still be answered with 504 instead of a dropped connection
+const writeTimeoutMargin = 5 * time.Second
+
+// requestTimedOut answers 504 Gateway Timeout and returns true if the request's deadline has passed.
+// Handlers call it between pipeline stages, since processing itself cannot be interrupted.
+func requestTimedOut(ctx context.Context, w http.ResponseWriter) bool {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false
 	}
+	http.Error(w, fmt.Sprintf("Request exceeded the timeout of %s", requestTimeout), http.StatusGatewayTimeout)
+	return true
 }
 
 func handleProxy(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	// HEAD is answered like GET, without the body, for cheap availability checks
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// Continue the caller's trace from its traceparent header; a no-op unless tracing is configured
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, "GET /proxy", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	urlParam := r.URL.Query().Get("url")
 	if urlParam == "" {
 		http.Error(w, "Missing 'url' parameter", http.StatusBadRequest)
@@ -56,170 +200,481 @@ func handleProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse optional date filtering parameters
-	fromParam := r.URL.Query().Get("from")
-	toParam := r.URL.Query().Get("to")
-
-	var fromDate, toDate *time.Time
-
-	if fromParam != "" {
-		parsed, err := time.Parse("2006-01-02", fromParam)
-		if err != nil {
-			http.Error(w, "Invalid 'from' date format. Use YYYY-MM-DD", http.StatusBadRequest)
-			return
-		}
-		fromDate = &parsed
+	// Calendar apps hand out webcal:// links; fetch them over HTTPS
+	fetchURL, ok := feedFetchURL(parsedURL)
+	if !ok {
+		http.Error(w, "Unsupported 'url' scheme. Use http, https, or webcal", http.StatusBadRequest)
+		return
 	}
+	urlParam = fetchURL.String()
 
-	if toParam != "" {
-		parsed, err := time.Parse("2006-01-02", toParam)
-		if err != nil {
-			http.Error(w, "Invalid 'to' date format. Use YYYY-MM-DD", http.StatusBadRequest)
-			return
-		}
-		toDate = &parsed
+	opts, err := parseRequestOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Use http.Client with timeout to address gosec G107
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	fetchCtx, fetchSpan := tracer().Start(ctx, "fetch upstream", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("server.address", fetchURL.Host)))
+	feed, err := fetchUpstream(fetchCtx, urlParam)
+	if err == nil && !opts.Force {
+		err = checkDeclaredContentType(feed)
 	}
-	resp, err := client.Get(urlParam)
-	if err != nil || resp.StatusCode != http.StatusOK {
+	endSpan(fetchSpan, err)
+	var circuitErr circuitOpenError
+	if requestTimedOut(ctx, w) {
+		log.Printf("Timed out fetching %s: %v", urlParam, err)
+		return
+	} else if errors.As(err, &circuitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(circuitErr.wait.Seconds()))))
+		http.Error(w, circuitErr.Error(), http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, icalfix.ErrNonCalendarContent) {
+		log.Printf("Rejected %s: %v", urlParam, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	} else if errors.Is(err, errICalTooLarge) {
+		log.Printf("Rejected %s: %v", urlParam, err)
+		http.Error(w, fmt.Sprintf("Upstream calendar exceeds the maximum size of %d bytes", maxICalBytes), http.StatusBadGateway)
+		return
+	} else if errors.Is(err, errReadUpstream) {
+		http.Error(w, "Failed to read iCal file content", http.StatusInternalServerError)
+		return
+	} else if err != nil {
+		log.Printf("Failed to fetch %s: %v", urlParam, err)
 		http.Error(w, "Failed to fetch iCal file", http.StatusInternalServerError)
 		return
 	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			log.Printf("Error closing response body: %v", closeErr)
-		}
-	}()
 
-	icalData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Failed to read iCal file content", http.StatusInternalServerError)
+	// Answer conditional requests before processing, so unchanged calendars cost neither CPU nor bandwidth
+	lastModified := feedLastModified(feed, opts)
+	if !lastModified.IsZero() && notModifiedSince(r, lastModified) {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	fixedICal, err := ProcessICalData(icalData, fromDate, toDate)
-	if err != nil {
+	opts.Charset = feed.charset
+	if opts.Geocode {
+		opts.ReverseGeocode = reverseGeocoder(ctx)
+	}
+	_, processSpan := tracer().Start(ctx, "process calendar")
+	fixedICal, report, err := icalfix.ProcessICalDataWithReport(feed.data, &opts.ProcessOptions)
+	if report != nil {
+		processSpan.SetAttributes(
+			attribute.Int("ical.events_in", report.EventsIn),
+			attribute.Int("ical.events_out", report.EventsOut),
+			attribute.Int("ical.fixes", len(report.Fixes)),
+		)
+	}
+	endSpan(processSpan, err)
+	if requestTimedOut(ctx, w) {
+		log.Printf("Timed out processing %s", urlParam)
+		return
+	} else if errors.Is(err, icalfix.ErrNonCalendarContent) {
+		log.Printf("Rejected %s: %v", urlParam, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	} else if errors.Is(err, icalfix.ErrEventNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, "Failed to process iCal data: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/calendar")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(fixedICal)); err != nil {
-		log.Printf("Failed to write response: %v", err)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
 	}
+	serveProcessedCalendar(w, r, fixedICal, report, opts)
 }
 
-// ProcessICalData takes raw iCal data and returns a processed version with optional date filtering
-func ProcessICalData(icalData []byte, fromDate, toDate *time.Time) (string, error) {
-	if len(icalData) == 0 {
-		return "", fmt.Errorf("empty iCal data")
+// feedLastModified returns the Last-Modified time of the proxy response for a feed: the upstream's
+// Last-Modified header, or else the latest LAST-MODIFIED of its components. It is zero, disabling
+// conditional requests, when neither is known or when the response also depends on the current time.
+func feedLastModified(feed upstreamFeed, opts *requestOptions) time.Time {
+	if opts.Upcoming || opts.RelativeRange {
+		return time.Time{}
 	}
+	lastModified := feed.lastModified
+	if lastModified.IsZero() {
+		lastModified = icalfix.LastModified(feed.data)
+	}
+	// HTTP dates have a resolution of one second
+	return lastModified.UTC().Truncate(time.Second)
+}
 
-	log.Printf("Starting iCal processing for %d bytes of data", len(icalData))
+// notModifiedSince reports whether the client's If-Modified-Since is at or after lastModified
+func notModifiedSince(r *http.Request, lastModified time.Time) bool {
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.After(since)
+}
+
+// handleFix processes iCal data posted as the request body instead of fetching it from a URL.
+// The data is either the raw body or, for web forms, the 'ical' file field of a multipart/form-data
+// upload; uploads are returned as a file download. It honors the same query parameters as /proxy.
+func handleFix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
 
-	calendar, err := ics.ParseCalendar(bytes.NewReader(icalData))
+	opts, err := parseRequestOptions(r.URL.Query())
 	if err != nil {
-		return "", fmt.Errorf("invalid iCal format: %w", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Geocode {
+		opts.ReverseGeocode = reverseGeocoder(ctx)
 	}
 
-	// Apply date filtering if specified
-	if fromDate != nil || toDate != nil {
-		filterEventsByDate(calendar, fromDate, toDate)
+	var icalData []byte
+	var fileName string
+	if mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		icalData, fileName, err = readUploadedFile(w, r)
+	} else {
+		opts.Charset = params["charset"]
+		icalData, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxICalBytes))
+	}
+	var maxBytesErr *http.MaxBytesError
+	var uploadErr paramError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, errICalTooLarge) {
+		http.Error(w, fmt.Sprintf("Request body exceeds the maximum size of %d bytes", maxICalBytes), http.StatusRequestEntityTooLarge)
+		return
+	} else if errors.As(err, &uploadErr) {
+		http.Error(w, uploadErr.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
 	}
 
-	// Apply comprehensive fixes to ensure RFC 5545 compliance
-	fixLog := fixCalendar(calendar)
+	fixedICal, report, err := icalfix.ProcessICalDataWithReport(icalData, &opts.ProcessOptions)
+	if requestTimedOut(ctx, w) {
+		return
+	} else if errors.Is(err, icalfix.ErrNonCalendarContent) {
+		http.Error(w, "Request body is not iCal data", http.StatusBadRequest)
+		return
+	} else if errors.Is(err, icalfix.ErrEventNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to process iCal data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Serialize with proper CRLF line endings (RFC 5545 requirement)
-	fixedICal := calendar.Serialize(ics.WithNewLine("\r\n"))
+	// Return uploads as a download named after the original file unless the filename parameter names it
+	if fileName != "" && opts.FileName == "" && !opts.DryRun && opts.Format == formatICS {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	}
+	serveProcessedCalendar(w, r, fixedICal, report, opts)
+}
 
-	// Apply post-serialization fixes for issues that can't be handled during object manipulation
-	fixedICal = applyPostSerializationFixes(fixedICal, fixLog)
+// uploadField is the multipart/form-data field that carries the file uploaded to /fix
+const uploadField = "ical"
 
-	// Log summary of fixes applied
-	log.Printf("iCal processing complete. %s", fixLog.GetSummary())
+// uploadExtensions are the file extensions accepted for uploads to /fix
+var uploadExtensions = []string{".ics", ".ical", ".icalendar", ".ifb"}
 
-	return fixedICal, nil
-}
+// multipartOverhead is the room left for multipart boundaries, headers, and other small form
+// fields on top of maxICalBytes
+const multipartOverhead = 64 << 10
 
-// filterEventsByDate removes events outside the specified date range
-func filterEventsByDate(calendar *ics.Calendar, fromDate, toDate *time.Time) {
-	events := calendar.Events()
-	eventsToRemove := []*ics.VEvent{}
+// readUploadedFile reads the 'ical' file of a multipart/form-data request and returns its content
+// and file name. The file must have a calendar extension and look like iCal data.
+func readUploadedFile(w http.ResponseWriter, r *http.Request) ([]byte, string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxICalBytes+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", paramError("Invalid multipart/form-data body")
+	}
 
-	for _, event := range events {
-		shouldRemove := false
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, "", paramError(fmt.Sprintf("Missing '%s' file field", uploadField))
+		} else if err != nil {
+			return nil, "", err
+		}
+		if part.FormName() != uploadField {
+			continue
+		}
 
-		// Get event start time
-		startProp := event.GetProperty(ics.ComponentPropertyDtStart)
-		if startProp != nil {
-			if eventStart, err := parseEventDate(startProp.Value); err == nil {
-				// Check if event is before fromDate
-				if fromDate != nil && eventStart.Before(*fromDate) {
-					shouldRemove = true
-				}
+		if part.FileName() == "" {
+			return nil, "", paramError(fmt.Sprintf("The '%s' field must be a file", uploadField))
+		}
+		fileName := filepath.Base(part.FileName())
+		if !slices.Contains(uploadExtensions, strings.ToLower(filepath.Ext(fileName))) {
+			return nil, "", paramError(fmt.Sprintf("Unsupported file '%s'. Upload an .ics file", fileName))
+		}
 
-				// Check if event is after toDate
-				if toDate != nil && eventStart.After(toDate.AddDate(0, 0, 1)) { // Add 1 day to include events on toDate
-					shouldRemove = true
-				}
-			}
+		data, err := io.ReadAll(io.LimitReader(part, maxICalBytes+1))
+		if err != nil {
+			return nil, "", err
+		}
+		if int64(len(data)) > maxICalBytes {
+			return nil, "", errICalTooLarge
+		}
+		if !icalfix.LooksLikeICal(data) {
+			return nil, "", paramError(fmt.Sprintf("Uploaded file '%s' is not iCal data", fileName))
+		}
+		return data, fileName, nil
+	}
+}
+
+// serveProcessedCalendar writes a processed calendar in the requested format,
+// or only the processing report for dry runs
+func serveProcessedCalendar(w http.ResponseWriter, r *http.Request, fixedICal string, report *icalfix.ProcessReport, opts *requestOptions) {
+	setStatsHeaders(w, report)
+	fileName := responseFileName(fixedICal, opts)
+
+	if opts.DryRun {
+		setContentDisposition(w, "inline", fileName+".json")
+		body, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, "Failed to encode dry run report", http.StatusInternalServerError)
+			return
 		}
+		writeProxyResponse(w, r, contentTypeJSON, body)
+		return
+	}
 
-		if shouldRemove {
-			eventsToRemove = append(eventsToRemove, event)
+	if opts.Format == formatZip {
+		archive, err := buildCalendarZip(fixedICal, opts.Split)
+		if err != nil {
+			log.Printf("Failed to build zip archive: %v", err)
+			http.Error(w, "Failed to build zip archive", http.StatusInternalServerError)
+			return
 		}
+		setContentDisposition(w, "attachment", fileName+".zip")
+		writeProxyResponse(w, r, "application/zip", archive)
+		return
+	}
+
+	if opts.Format == formatNDJSON {
+		setContentDisposition(w, "inline", fileName+".ndjson")
+		writeEventStream(w, r, fixedICal)
+		return
 	}
 
-	// Remove filtered events
-	for _, event := range eventsToRemove {
-		calendar.RemoveEvent(event.Id())
+	if opts.Fragment {
+		fragments, err := icalfix.EventFragments(fixedICal)
+		if err != nil {
+			log.Printf("Failed to extract event fragments: %v", err)
+			http.Error(w, "Failed to extract event fragments", http.StatusInternalServerError)
+			return
+		}
+		setContentDisposition(w, "inline", fileName+".txt")
+		writeProxyResponse(w, r, contentTypeFragment, []byte(fragments))
+		return
 	}
 
-	log.Printf("Filtered out %d events based on date range", len(eventsToRemove))
+	// Passed-through data keeps the upstream's encoding, so it keeps its charset as well
+	contentType := contentTypeCalendar
+	if opts.Passthrough {
+		contentType = "text/calendar"
+		if opts.Charset != "" {
+			contentType = mime.FormatMediaType(contentType, map[string]string{"charset": opts.Charset})
+		}
+	}
+	setContentDisposition(w, "inline", fileName+".ics")
+	writeProxyResponse(w, r, contentType, []byte(fixedICal))
 }
 
-// parseEventDate parses various iCal date formats
-func parseEventDate(dateStr string) (time.Time, error) {
-	// Try different date formats used in iCal
-	formats := []string{
-		"20060102T150405Z",     // UTC format
-		"20060102T150405",      // Local format
-		"20060102",             // Date only
-		"2006-01-02T15:04:05Z", // RFC3339 UTC
-		"2006-01-02T15:04:05",  // RFC3339 local
-		"2006-01-02",           // Date only with dashes
+// writeEventStream writes the events of a processed calendar as newline-delimited JSON, one event
+// object per line, and flushes after every line so that clients can render the first events while
+// the rest are still written. The status is sent with the first event, so a calendar that cannot
+// be walked still gets a 500.
+func writeEventStream(w http.ResponseWriter, r *http.Request, fixedICal string) {
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
 	}
 
-	for _, format := range formats {
-		if t, err := time.Parse(format, dateStr); err == nil {
-			return t, nil
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	started := false
+	err := icalfix.WalkEvents(fixedICal, func(event icalfix.Event) error {
+		started = true
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
 		}
+		return nil
+	})
+	if err != nil && !started {
+		log.Printf("Failed to read processed events: %v", err)
+		http.Error(w, "Failed to encode events", http.StatusInternalServerError)
+	} else if err != nil {
+		log.Printf("Failed to stream events: %v", err)
+	}
+}
+
+// responseFileName names the response file after the 'filename' parameter or the calendar's
+// X-WR-CALNAME, falling back to "calendar"
+func responseFileName(fixedICal string, opts *requestOptions) string {
+	if opts.FileName != "" {
+		return opts.FileName
+	}
+	if name := icalfix.FileName(icalfix.CalendarName(fixedICal)); name != "" {
+		return name
 	}
+	return "calendar"
+}
 
-	return time.Time{}, fmt.Errorf("unable to parse date: %s", dateStr)
+// setContentDisposition sets the Content-Disposition header unless the handler already chose one,
+// like /fix does for uploaded files. ASCII names are always quoted since some clients ignore bare
+// tokens; other names are sent in the RFC 2231 filename* form.
+func setContentDisposition(w http.ResponseWriter, disposition string, fileName string) {
+	if w.Header().Get("Content-Disposition") != "" {
+		return
+	}
+	value := mime.FormatMediaType(disposition, map[string]string{"filename": fileName})
+	if !strings.Contains(value, "filename*=") {
+		value = disposition + `; filename="` + fileName + `"`
+	}
+	w.Header().Set("Content-Disposition", value)
 }
 
-// FixICalData is kept for backward compatibility but now uses ProcessICalData
-func FixICalData(icalData []byte) (string, error) {
-	return ProcessICalData(icalData, nil, nil)
+// setStatsHeaders summarizes the processed calendar in response headers for debugging.
+// X-ICal-Todos is omitted for calendars without TODOs, which is the common case, and
+// X-ICal-Truncated is only set when MAX_OUTPUT_EVENTS cut the calendar short.
+func setStatsHeaders(w http.ResponseWriter, report *icalfix.ProcessReport) {
+	w.Header().Set("X-ICal-Events", strconv.Itoa(report.EventsOut))
+	if report.TodosOut > 0 {
+		w.Header().Set("X-ICal-Todos", strconv.Itoa(report.TodosOut))
+	}
+	w.Header().Set("X-ICal-Source-Bytes", strconv.Itoa(report.BytesIn))
+	if report.Truncated {
+		w.Header().Set("X-ICal-Truncated", "true")
+	}
+	setWarningHeaders(w, report.Warnings)
 }
 
-// handleHealth provides a simple health check endpoint
+// maxWarningHeaders caps the X-ICal-Warnings headers of a response, so that a feed with many broken
+// events cannot produce more header data than clients accept
+const maxWarningHeaders = 10
+
+// setWarningHeaders adds an X-ICal-Warnings header for each processing warning, up to
+// maxWarningHeaders and a last one counting the rest. Warnings quote feed data, so anything but
+// printable ASCII is replaced and long values are cut.
+func setWarningHeaders(w http.ResponseWriter, warnings []string) {
+	for i, warning := range warnings {
+		if i == maxWarningHeaders {
+			w.Header().Add("X-ICal-Warnings", fmt.Sprintf("and %d more warnings", len(warnings)-i))
+			return
+		}
+		warning = strings.Map(func(r rune) rune {
+			if r < ' ' || r > '~' {
+				return '?'
+			}
+			return r
+		}, warning)
+		if len(warning) > 200 {
+			warning = warning[:200] + "..."
+		}
+		w.Header().Add("X-ICal-Warnings", warning)
+	}
+}
+
+// Content types of processed calendars, event fragments, event streams, and dry-run reports. Processing always
+// produces UTF-8, whatever charset the upstream used, so the charset is stated instead of left to
+// clients to guess. Fragments are not a valid calendar, so they are not labelled text/calendar
+// lest calendar clients try to import them.
+const (
+	contentTypeCalendar = "text/calendar; charset=utf-8"
+	contentTypeFragment = "text/x-ical-fragment; charset=utf-8"
+	contentTypeNDJSON   = "application/x-ndjson; charset=utf-8"
+	contentTypeJSON     = "application/json; charset=utf-8"
+)
+
+// writeProxyResponse writes a successful proxy response; HEAD requests get the headers only
+func writeProxyResponse(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// handleHealth provides a simple health check endpoint.
+// With deep=true it also fetches healthcheckURL, for readiness probes that should fail
+// when outbound networking is broken.
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
 
+	deep, err := parseBoolParam(r.URL.Query(), "deep")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := healthResponse{Status: "healthy", Service: "ical-proxy"}
+	status := http.StatusOK
+	if deep && healthcheckURL != "" {
+		if err := checkUpstreamHealth(r.Context(), healthcheckURL); err != nil {
+			log.Printf("Deep health check of %s failed: %v", healthcheckURL, err)
+			response.Status = "degraded"
+			response.Error = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode health response", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(`{"status":"healthy","service":"ical-proxy"}`)); err != nil {
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
 		log.Printf("Failed to write health response: %v", err)
 	}
 }
+
+// healthResponse is the JSON body of the health endpoint
+type healthResponse struct {
+	Status  string `json:"status"`
+	Service string `json:"service"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleVersion reports the build information of the running binary
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := json.Marshal(versionResponse{Version: Version, Commit: Commit, BuildTime: BuildTime})
+	if err != nil {
+		http.Error(w, "Failed to encode version response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Failed to write version response: %v", err)
+	}
+}
+
+// versionResponse is the JSON body of the version endpoint
+type versionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
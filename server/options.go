@@ -0,0 +1,462 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/konairius/ical-proxy/pkg/icalfix"
+)
+
+// maxOutputEvents caps the number of events in a response; zero means no limit.
+// Configured via MAX_OUTPUT_EVENTS.
+var maxOutputEvents = 0
+
+// requestOptions are the options of a proxy request: how to process the calendar and how to respond
+type requestOptions struct {
+	icalfix.ProcessOptions
+
+	// Format selects the response format: "ics" (default), "zip", or "ndjson"
+	Format string
+	// Split partitions the zip output into one calendar per "category"; empty means a single calendar
+	Split string
+	// FileName is the file name in Content-Disposition without extension; empty means the
+	// calendar name or "calendar"
+	FileName string
+
+	// DryRun returns a JSON report of the applied fixes instead of the calendar
+	DryRun bool
+	// Fragment returns only the VEVENT blocks, without the VCALENDAR wrapper
+	Fragment bool
+	// Geocode fills in missing event locations from their GEO coordinates via geocoderURL
+	Geocode bool
+	// Force treats the upstream response as a calendar whatever its declared Content-Type
+	Force bool
+	// RelativeRange is set when the date range was resolved against the current time by 'window'
+	RelativeRange bool
+}
+
+// paramError describes an invalid query parameter; its message is returned to the client as-is
+type paramError string
+
+func (e paramError) Error() string {
+	return string(e)
+}
+
+// parseRequestOptions builds requestOptions from the query parameters of a proxy request
+func parseRequestOptions(query url.Values) (*requestOptions, error) {
+	opts := &requestOptions{}
+	opts.MaxOutputEvents = maxOutputEvents
+
+	// Parse optional date filtering parameters
+	filterLocation := time.UTC
+	if tzParam := query.Get("filter_tz"); tzParam != "" {
+		loc, err := icalfix.LoadLocation(tzParam)
+		if err != nil {
+			return nil, paramError("Invalid 'filter_tz' value. Use an IANA time zone like Europe/Berlin")
+		}
+		filterLocation = loc
+		opts.FilterLocation = loc
+	}
+
+	if fromParam := query.Get("from"); fromParam != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", fromParam, filterLocation)
+		if err != nil {
+			return nil, paramError("Invalid 'from' date format. Use YYYY-MM-DD")
+		}
+		opts.FromDate = &parsed
+	}
+
+	if toParam := query.Get("to"); toParam != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", toParam, filterLocation)
+		if err != nil {
+			return nil, paramError("Invalid 'to' date format. Use YYYY-MM-DD")
+		}
+		opts.ToDate = &parsed
+	}
+
+	if windowParam := query.Get("window"); windowParam != "" {
+		if opts.FromDate != nil || opts.ToDate != nil {
+			return nil, paramError("Invalid 'window' value. Use either 'window' or 'from'/'to'")
+		}
+		from, to, ok := parseWindow(windowParam, icalfix.Now().In(filterLocation))
+		if !ok {
+			return nil, paramError("Invalid 'window' value. Use a positive number of days, weeks, or months like 30d, 2w, or 6mo")
+		}
+		opts.FromDate, opts.ToDate = &from, &to
+		opts.RelativeRange = true
+	}
+
+	if opts.FromDate != nil && opts.ToDate != nil && opts.FromDate.After(*opts.ToDate) {
+		return nil, paramError("Invalid date range: 'from' must not be after 'to'")
+	}
+
+	if query.Has("prodid") {
+		prodID, err := icalfix.FormatProdID(query.Get("prodid"))
+		if err != nil {
+			return nil, paramError("Invalid 'prodid' value. Use a non-empty product identifier")
+		}
+		opts.ProdID = prodID
+	}
+	if query.Has("force_prodid") {
+		prodID, err := icalfix.FormatProdID(query.Get("force_prodid"))
+		if err != nil {
+			return nil, paramError("Invalid 'force_prodid' value. Use a non-empty product identifier")
+		}
+		opts.ForceProdID = prodID
+	}
+
+	if query.Has("default_summary") {
+		summary, ok := formatSummary(query.Get("default_summary"))
+		if !ok {
+			return nil, paramError("Invalid 'default_summary' value. Use a non-empty title without control characters")
+		}
+		opts.DefaultSummary = summary
+	}
+
+	if query.Has("name") {
+		name := strings.TrimSpace(query.Get("name"))
+		if name == "" || strings.ContainsFunc(name, unicode.IsControl) {
+			return nil, paramError("Invalid 'name' value. Use a non-empty calendar name")
+		}
+		opts.Name = name
+	}
+	if query.Has("color") {
+		color, err := icalfix.FormatEventColor(query.Get("color"))
+		if err != nil {
+			return nil, paramError("Invalid 'color' value. Use a CSS color name like teal")
+		}
+		opts.Color = color
+	}
+
+	if query.Has("uid") {
+		uid := strings.TrimSpace(query.Get("uid"))
+		if uid == "" {
+			return nil, paramError("Invalid 'uid' value. Use the UID of an event in the feed")
+		}
+		opts.UID = uid
+	}
+
+	// Parse optional category filters
+	opts.Category = parseCategoryList(query.Get("category"))
+	opts.CategoryAll = parseCategoryList(query.Get("category_all"))
+
+	// hide_uid may be repeated, and each value may list several UIDs
+	for _, value := range query["hide_uid"] {
+		opts.HideUIDs = append(opts.HideUIDs, parseUIDList(value)...)
+	}
+
+	// Parse the optional location filter; regex=true matches a regular expression instead of a substring
+	useRegex, err := parseBoolParam(query, "regex")
+	if err != nil {
+		return nil, err
+	}
+	if location := query.Get("location"); location != "" {
+		pattern, err := icalfix.LocationPattern(location, useRegex)
+		if err != nil {
+			return nil, paramError("Invalid 'location' value. Use a valid regular expression with regex=true")
+		}
+		opts.Location = pattern
+	}
+
+	// Parse the list of event fixes to skip; unknown identifiers are ignored by the fixer with a warning
+	opts.DisableFixes = parseFixList(query.Get("disable"))
+
+	// Parse optional property selection parameters
+	opts.Only = parsePropertyList(query.Get("only"))
+	opts.Strip = parsePropertyList(query.Get("strip"))
+
+	if baseParam := query.Get("rewrite_url_base"); baseParam != "" {
+		base, err := url.Parse(baseParam)
+		if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+			return nil, paramError("Invalid 'rewrite_url_base' value. Use an absolute http or https URL")
+		}
+		opts.RewriteURLBase = base
+	}
+
+	if status := query.Get("set_status"); status != "" {
+		if opts.SetStatus, err = icalfix.FormatEventStatus(status); err != nil {
+			return nil, paramError("Invalid 'set_status' value. Use TENTATIVE, CONFIRMED, CANCELLED, or an X- name")
+		}
+	}
+
+	if color := query.Get("event_color"); color != "" {
+		if opts.EventColor, err = icalfix.FormatEventColor(color); err != nil {
+			return nil, paramError("Invalid 'event_color' value. Use a CSS color name like teal")
+		}
+	}
+
+	if domain := query.Get("uid_domain"); domain != "" {
+		if !isDomainName(domain) {
+			return nil, paramError("Invalid 'uid_domain' value. Use a domain name like example.com")
+		}
+		opts.UIDDomain = domain
+	}
+
+	switch alarms := strings.ToLower(query.Get("alarms")); alarms {
+	case "", icalfix.AlarmsDisplay, icalfix.AlarmsStrip:
+		opts.Alarms = alarms
+	default:
+		return nil, paramError("Invalid 'alarms' value. Use 'display' or 'strip'")
+	}
+
+	switch categories := strings.ToLower(query.Get("categories")); categories {
+	case "", icalfix.CategoriesJoin, icalfix.CategoriesSplit:
+		opts.Categories = categories
+	default:
+		return nil, paramError("Invalid 'categories' value. Use 'join' or 'split'")
+	}
+
+	if opts.Anonymize, err = parseBoolParam(query, "anonymize"); err != nil {
+		return nil, err
+	}
+	if opts.Upcoming, err = parseBoolParam(query, "upcoming"); err != nil {
+		return nil, err
+	}
+	if opts.SplitMidnight, err = parseBoolParam(query, "split_midnight"); err != nil {
+		return nil, err
+	}
+	if opts.MergeAdjacent, err = parseBoolParam(query, "merge_adjacent"); err != nil {
+		return nil, err
+	}
+	if opts.PruneDangling, err = parseBoolParam(query, "prune_dangling"); err != nil {
+		return nil, err
+	}
+	if opts.Salvage, err = parseBoolParam(query, "salvage"); err != nil {
+		return nil, err
+	}
+	if opts.HideCancelled, err = parseBoolParam(query, "hide_cancelled"); err != nil {
+		return nil, err
+	}
+	if opts.ApplyCalendarTZ, err = parseBoolParam(query, "apply_calendar_tz"); err != nil {
+		return nil, err
+	}
+	if opts.TitleCaseCategories, err = parseBoolParam(query, "title_case_categories"); err != nil {
+		return nil, err
+	}
+	if opts.Minify, err = parseBoolParam(query, "minify"); err != nil {
+		return nil, err
+	}
+	if opts.StableUID, err = parseBoolParam(query, "stable_uid"); err != nil {
+		return nil, err
+	}
+	if opts.IncludeFreeBusy, err = parseBoolParam(query, "include_freebusy"); err != nil {
+		return nil, err
+	}
+	if opts.IncludeFreeBusy && (opts.FromDate == nil || opts.ToDate == nil) {
+		return nil, paramError("The 'include_freebusy' parameter requires a date range. Use 'window' or both 'from' and 'to'")
+	}
+	if opts.DryRun, err = parseBoolParam(query, "dry_run"); err != nil {
+		return nil, err
+	}
+	if opts.Geocode, err = parseBoolParam(query, "geocode"); err != nil {
+		return nil, err
+	}
+	if opts.Geocode && geocoderURL == "" {
+		return nil, paramError("The 'geocode' parameter requires a geocoding provider, which this server does not configure")
+	}
+	if opts.Force, err = parseBoolParam(query, "force"); err != nil {
+		return nil, err
+	}
+	opts.SkipPreamble = opts.Force
+	if opts.Passthrough, err = parseBoolParam(query, "passthrough"); err != nil {
+		return nil, err
+	}
+	if opts.CRLF, err = parseBoolParam(query, "crlf"); err != nil {
+		return nil, err
+	}
+	if opts.CRLF && !opts.Passthrough {
+		return nil, paramError("The 'crlf' parameter requires passthrough=true")
+	}
+
+	if limitParam := query.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 {
+			return nil, paramError("Invalid 'limit' value. Use a positive integer")
+		}
+		opts.Limit = limit
+	}
+
+	if offsetParam := query.Get("offset"); offsetParam != "" {
+		offset, err := strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			return nil, paramError("Invalid 'offset' value. Use a non-negative integer")
+		}
+		opts.Offset = offset
+	}
+
+	if ttlParam := query.Get("ttl"); ttlParam != "" {
+		ttl, err := icalfix.ParseDuration(ttlParam)
+		if err != nil || ttl < time.Minute {
+			return nil, paramError("Invalid 'ttl' value. Use a duration of at least a minute like PT1H or P1D")
+		}
+		opts.RefreshInterval = ttl
+	}
+
+	if reminderParam := query.Get("allday_reminder"); reminderParam != "" {
+		lead, err := time.ParseDuration(reminderParam)
+		if err != nil || lead <= 0 {
+			return nil, paramError("Invalid 'allday_reminder' value. Use a duration like 18h or 90m")
+		}
+		opts.AllDayReminder = lead
+	}
+
+	switch format := strings.ToLower(query.Get("format")); format {
+	case "", formatICS:
+		opts.Format = formatICS
+	case formatZip, formatNDJSON:
+		opts.Format = format
+	default:
+		return nil, paramError("Invalid 'format' value. Use 'ics', 'zip', or 'ndjson'")
+	}
+	if opts.Format == formatNDJSON && opts.Passthrough {
+		return nil, paramError("format=ndjson cannot be combined with passthrough=true")
+	}
+
+	if opts.Fragment, err = parseBoolParam(query, "fragment"); err != nil {
+		return nil, err
+	}
+	if opts.Fragment && (opts.Format != formatICS || opts.Passthrough) {
+		return nil, paramError("The 'fragment' parameter cannot be combined with format=zip, format=ndjson, or passthrough=true")
+	}
+
+	switch split := strings.ToLower(query.Get("split")); split {
+	case "":
+	case splitCategory:
+		if opts.Format != formatZip {
+			return nil, paramError("The 'split' parameter requires format=zip")
+		}
+		opts.Split = split
+	default:
+		return nil, paramError("Invalid 'split' value. Use 'category'")
+	}
+
+	if fileName := query.Get("filename"); fileName != "" {
+		for _, ext := range []string{".ics", ".zip", ".json", ".txt", ".ndjson"} {
+			if len(fileName) > len(ext) && strings.EqualFold(fileName[len(fileName)-len(ext):], ext) {
+				fileName = fileName[:len(fileName)-len(ext)]
+				break
+			}
+		}
+		opts.FileName = icalfix.FileName(fileName)
+		if opts.FileName == "" {
+			return nil, paramError("Invalid 'filename' value. Use letters, digits, '-' or '_'")
+		}
+	}
+
+	return opts, nil
+}
+
+// formatSummary trims a default event title and reports whether it is usable as SUMMARY text.
+// Escaping is left to the serializer, so the title may contain commas and semicolons.
+func formatSummary(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	return value, value != "" && !strings.ContainsFunc(value, unicode.IsControl)
+}
+
+// parseBoolParam parses an optional boolean query parameter such as "1", "true", or "false"
+func parseBoolParam(query url.Values, name string) (bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, paramError(fmt.Sprintf("Invalid '%s' value. Use true or false", name))
+	}
+	return parsed, nil
+}
+
+// parsePropertyList splits a comma-separated list of property names into upper-case names
+func parsePropertyList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseFixList splits a comma-separated list of fix identifiers into lower case, dropping empty entries
+func parseFixList(value string) []string {
+	var fixes []string
+	for _, fix := range strings.Split(value, ",") {
+		if fix = strings.ToLower(strings.TrimSpace(fix)); fix != "" {
+			fixes = append(fixes, fix)
+		}
+	}
+	return fixes
+}
+
+// parseCategoryList splits a comma-separated list of categories, dropping empty entries
+func parseCategoryList(value string) []string {
+	var categories []string
+	for _, category := range strings.Split(value, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
+
+// parseUIDList splits a comma-separated list of UIDs, dropping empty entries
+func parseUIDList(value string) []string {
+	var uids []string
+	for _, uid := range strings.Split(value, ",") {
+		if uid = strings.TrimSpace(uid); uid != "" {
+			uids = append(uids, uid)
+		}
+	}
+	return uids
+}
+
+// isDomainName reports whether value is a DNS name like example.com: dot-separated labels of
+// letters, digits, and inner hyphens, each at most 63 characters
+func isDomainName(value string) bool {
+	if len(value) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(value, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseWindow turns a relative window like 30d, 2w, or 6mo into a date range starting now. The
+// range ends with the last day of the window, like a 'to' date, in the zone of now.
+func parseWindow(value string, now time.Time) (time.Time, time.Time, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	digits := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	if digits <= 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	n, err := strconv.Atoi(value[:digits])
+	if err != nil || n <= 0 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	var end time.Time
+	switch strings.TrimSpace(value[digits:]) {
+	case "d", "day", "days":
+		end = now.AddDate(0, 0, n)
+	case "w", "week", "weeks":
+		end = now.AddDate(0, 0, 7*n)
+	case "mo", "month", "months":
+		end = now.AddDate(0, n, 0)
+	default:
+		return time.Time{}, time.Time{}, false
+	}
+	return now, time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, now.Location()), true
+}
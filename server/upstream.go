@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konairius/ical-proxy/pkg/icalfix"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errReadUpstream is returned when the upstream responded but its body could not be read
+var errReadUpstream = errors.New("failed to read upstream body")
+
+// errICalTooLarge is returned when the upstream data exceeds maxICalBytes
+var errICalTooLarge = errors.New("iCal data too large")
+
+// maxICalBytes limits the size of iCal data read from upstreams and request bodies.
+// Configured via MAX_ICAL_BYTES.
+var maxICalBytes int64 = 10 << 20
+
+// upstreamPeekLength is how much of an upstream body is inspected before it is read in full, so
+// that an HTML or JSON error page is rejected after its first bytes
+const upstreamPeekLength = 512
+
+// upstreamTimeout bounds the total time spent fetching a feed, including retries.
+// Configured via UPSTREAM_TIMEOUT.
+var upstreamTimeout = 30 * time.Second
+
+// upstreamRetries is how often a failed fetch is retried on connection errors and 5xx responses.
+// Configured via UPSTREAM_RETRIES.
+var upstreamRetries = 2
+
+// upstreamRetryDelay is the backoff before the first retry; it doubles for every further retry
+var upstreamRetryDelay = 500 * time.Millisecond
+
+// minRefreshInterval is the minimum time between two upstream fetches of the same URL.
+// The proxy keeps no response cache, so every request is a forced refresh; within the interval
+// the copy from the previous fetch is served instead of hitting the upstream again.
+// Configured via PROXY_MIN_REFRESH_INTERVAL; zero disables throttling.
+var minRefreshInterval time.Duration
+
+// upstreamFeed is the body of a fetched feed with the upstream's Last-Modified time and the media
+// type and charset of its Content-Type, if it sent them
+type upstreamFeed struct {
+	data         []byte
+	lastModified time.Time
+	mediaType    string
+	charset      string
+}
+
+// recentFetch is the last successful upstream fetch of a URL
+type recentFetch struct {
+	feed      upstreamFeed
+	fetchedAt time.Time
+}
+
+// refreshThrottle remembers recent upstream fetches per URL to enforce minRefreshInterval
+type refreshThrottle struct {
+	mu      sync.Mutex
+	fetches map[string]recentFetch
+}
+
+var upstreamThrottle = &refreshThrottle{fetches: make(map[string]recentFetch)}
+
+// get returns the last fetched feed of a URL if it is younger than the given interval
+func (rt *refreshThrottle) get(url string, interval time.Duration) (upstreamFeed, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	fetch, ok := rt.fetches[url]
+	if !ok || time.Since(fetch.fetchedAt) >= interval {
+		return upstreamFeed{}, false
+	}
+	return fetch.feed, true
+}
+
+// put records a fetched feed and drops entries that no longer throttle anything
+func (rt *refreshThrottle) put(url string, feed upstreamFeed, interval time.Duration) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	now := time.Now()
+	for key, fetch := range rt.fetches {
+		if now.Sub(fetch.fetchedAt) >= interval {
+			delete(rt.fetches, key)
+		}
+	}
+	rt.fetches[url] = recentFetch{feed: feed, fetchedAt: now}
+}
+
+// feedFetchURL returns the URL to fetch for a feed URL given by the client. The webcal and webcals
+// schemes are rewritten to https; any scheme other than http or https is rejected.
+func feedFetchURL(feedURL *url.URL) (*url.URL, bool) {
+	fetchURL := *feedURL
+	switch strings.ToLower(fetchURL.Scheme) {
+	case "http", "https":
+	case "webcal", "webcals":
+		fetchURL.Scheme = "https"
+	default:
+		return nil, false
+	}
+	if fetchURL.Host == "" {
+		return nil, false
+	}
+	return &fetchURL, true
+}
+
+// healthcheckURL is fetched by deep health checks to verify outbound connectivity; empty disables them.
+// Configured via HEALTHCHECK_URL.
+var healthcheckURL string
+
+// healthcheckTimeout is kept below the readiness probe timeout so a hanging upstream fails the check
+const healthcheckTimeout = 2 * time.Second
+
+// checkUpstreamHealth performs a single fetch of url without retries or throttling
+func checkUpstreamHealth(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthcheckTimeout)
+	defer cancel()
+
+	// Use http.Client with timeout to address gosec G107
+	client := &http.Client{
+		Timeout: healthcheckTimeout,
+	}
+	feed, _, err := fetchUpstreamOnce(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	return checkDeclaredContentType(feed)
+}
+
+// fetchUpstream downloads the iCal data at the given URL, honoring minRefreshInterval.
+// Connection errors and 5xx responses are retried with exponential backoff up to upstreamRetries
+// times, all within upstreamTimeout.
+func fetchUpstream(ctx context.Context, url string) (upstreamFeed, error) {
+	if minRefreshInterval > 0 {
+		if feed, ok := upstreamThrottle.get(url, minRefreshInterval); ok {
+			log.Printf("Serving recent copy of %s (minimum refresh interval %s)", url, minRefreshInterval)
+			return feed, nil
+		}
+	}
+
+	host := upstreamHost(url)
+	if ok, wait := upstreamBreaker.allow(host, time.Now()); !ok {
+		return upstreamFeed{}, circuitOpenError{host: host, wait: wait}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+	defer cancel()
+
+	// Use http.Client with timeout to address gosec G107
+	client := &http.Client{
+		Timeout: upstreamTimeout,
+	}
+
+	var feed upstreamFeed
+	var err error
+	var retryable bool
+	defer func() {
+		// Connection errors, timeouts, and 5xx responses count against the host; a caller that
+		// went away says nothing about it. Runs before cancel, so ctx.Err() is still meaningful.
+		if !errors.Is(ctx.Err(), context.Canceled) {
+			upstreamBreaker.record(host, err != nil && (retryable || ctx.Err() != nil), time.Now())
+		}
+	}()
+	for attempt := 0; ; attempt++ {
+		feed, retryable, err = fetchUpstreamOnce(ctx, client, url)
+		if err == nil || !retryable || attempt >= upstreamRetries {
+			break
+		}
+
+		delay := upstreamRetryDelay << attempt
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			log.Printf("Not retrying %s: %v (upstream timeout %s reached)", url, err, upstreamTimeout)
+			break
+		}
+		log.Printf("Retrying %s in %s after attempt %d failed: %v", url, delay, attempt+1, err)
+
+		select {
+		case <-ctx.Done():
+			return upstreamFeed{}, err
+		case <-time.After(delay):
+		}
+	}
+	if err != nil {
+		return upstreamFeed{}, err
+	}
+
+	if minRefreshInterval > 0 {
+		upstreamThrottle.put(url, feed, minRefreshInterval)
+	}
+	return feed, nil
+}
+
+// fetchUpstreamOnce performs a single upstream request and reports whether a failure is worth retrying
+func fetchUpstreamOnce(ctx context.Context, client *http.Client, url string) (upstreamFeed, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return upstreamFeed{}, false, err
+	}
+	// Asking for gzip explicitly turns off the transport's transparent decompression, so the
+	// body is decompressed by upstreamBody below
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Connection errors are transient unless the deadline has passed
+		return upstreamFeed{}, ctx.Err() == nil, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Error closing response body: %v", closeErr)
+		}
+	}()
+
+	// Recorded on the fetch span of handleProxy; the last attempt wins
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode != http.StatusOK {
+		return upstreamFeed{}, resp.StatusCode >= 500, fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+
+	body, compressed, err := upstreamBody(resp)
+	if err != nil {
+		return upstreamFeed{}, false, fmt.Errorf("%w: %v", errReadUpstream, err)
+	}
+	// Peeked bytes stay buffered and are part of the data read below. A short body ends the peek
+	// early; read errors are reported by io.ReadAll.
+	reader := bufio.NewReaderSize(body, upstreamPeekLength)
+	if prefix, _ := reader.Peek(upstreamPeekLength); icalfix.StartsLikeNonCalendar(prefix) {
+		return upstreamFeed{}, false, icalfix.NonCalendarContentError(prefix, "")
+	}
+	// The limit applies to the decompressed data, so a small gzip bomb is cut off after maxICalBytes
+	data, err := io.ReadAll(io.LimitReader(reader, maxICalBytes+1))
+	if err != nil {
+		return upstreamFeed{}, false, fmt.Errorf("%w: %v", errReadUpstream, err)
+	}
+	if int64(len(data)) > maxICalBytes {
+		if compressed {
+			return upstreamFeed{}, false, fmt.Errorf("%w: more than %d bytes after decompression", errICalTooLarge, maxICalBytes)
+		}
+		return upstreamFeed{}, false, fmt.Errorf("%w: more than %d bytes", errICalTooLarge, maxICalBytes)
+	}
+
+	// An unparseable Content-Type or Last-Modified is treated like a missing one
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return upstreamFeed{data: data, lastModified: lastModified, mediaType: mediaType, charset: params["charset"]}, false, nil
+}
+
+// checkDeclaredContentType rejects a feed that its upstream declared as HTML, typically an error
+// page, unless the body looks like iCal after all. Other declared types are not checked, since
+// origins serve valid calendars as text/plain or application/octet-stream; the body is sniffed
+// again when the calendar is processed either way. The 'force' parameter skips this check.
+func checkDeclaredContentType(feed upstreamFeed) error {
+	if feed.mediaType == "text/html" && !icalfix.LooksLikeICal(feed.data) {
+		return icalfix.NonCalendarContentError(feed.data, feed.mediaType)
+	}
+	return nil
+}
+
+// upstreamBody returns the body of an upstream response, decompressing it if the upstream sent gzip,
+// and reports whether it did
+func upstreamBody(resp *http.Response) (io.Reader, bool, error) {
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return resp.Body, false, nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		return reader, true, nil
+	default:
+		return nil, false, fmt.Errorf("unsupported Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+}